@@ -0,0 +1,68 @@
+package cmd
+
+import (
+	"github.com/fwang2/pi/fs"
+	"github.com/fwang2/pi/tui"
+	"github.com/spf13/cobra"
+)
+
+var browseApparent bool
+var browseIndex string
+var browseXDev bool
+var browseExclude excludeOpts
+
+func init() {
+	browseCmd.Flags().BoolVar(&browseApparent, "apparent", false, "Use apparent sizes, as reported by stat")
+	browseCmd.Flags().BoolVar(&browseXDev, "xdev", false, "Don't descend directories on other file systems")
+	browseCmd.Flags().StringVar(&browseIndex, "from-index", "", "Browse an index, read only")
+	browseExclude.addFlags(browseCmd)
+	rootCmd.AddCommand(browseCmd)
+}
+
+var browseCmd = &cobra.Command{
+	Use:   "browse [path]",
+	Short: "Browse disk usage interactively",
+	Long: `Walk a tree in parallel, then browse it interactively, largest first.
+
+  up/down, j/k     move            s   sort by size
+  enter, right, l  open directory  i   sort by inodes
+  left, h          back            space, m  mark
+  q                quit            d   delete marked, or current, entries
+
+Deletion asks for confirmation first. It is disabled after a walk
+stopped by Ctrl-C or --timeout, whose sizes are incomplete.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var ws *fs.WalkStat = new(fs.WalkStat)
+		ws.NumOfWorkers = NumOfWorkers
+		var wc *fs.WalkControl = new(fs.WalkControl)
+		wc.Verbose = Verbose
+		wc.DoTree = true
+		wc.TreeFiles = true
+		wc.DoProgress = true
+		wc.XDev = browseXDev
+		if browseApparent {
+			wc.SizeMode = fs.SIZE_APPARENT
+		}
+		wc.FromIndex = browseIndex
+		if wc.FromIndex != "" {
+			ws.RootPath = indexRootPath(args)
+			fs.IndexPrologue(wc)
+		} else {
+			ws.RootPath = fs.ParseRootPath(args)
+			fs.WalkPrologue(ws)
+		}
+
+		wc.Exclude = browseExclude.excluder(ws.RootPath)
+
+		handleInterrupt(wc)
+		runWalk(wc, ws)
+
+		b := tui.NewBrowser(ws.Tree)
+		b.ReadOnly = wc.FromIndex != ""
+		b.Partial = ws.Partial
+		if err := tui.Run(b); err != nil {
+			log.Fatalf("Can't browse: %v", err)
+		}
+	},
+}
@@ -13,8 +13,10 @@ var cc *fs.CopyControl = new(fs.CopyControl)
 var sources []string
 var dest string
 var copyMode int // control which function to run
+var cpExclude excludeOpts
 
 func init() {
+	cpExclude.addFlags(cpCmd)
 	rootCmd.AddCommand(cpCmd)
 }
 
@@ -84,12 +86,18 @@ var cpCmd = &cobra.Command{
 	},
 	Run: func(cmd *cobra.Command, args []string) {
 		cc.NumOfWorkers = NumOfWorkers
+		cc.Ctx = interruptContext()
+		// rules are relative to the source base, set by RunCopy
+		cc.Exclude = cpExclude.excluder("")
 		// start := time.Now()
 		log.Debugf("sources = %v, dest = %s \n", sources, dest)
 		if copyMode == fs.COPY_F2F {
-			fs.CopyFile(sources[0], dest)
+			fs.CopyFileCtx(cc.Ctx, sources[0], dest)
 		} else {
 			fs.RunCopy(cc, sources, dest)
 		}
+		if cc.Ctx.Err() != nil {
+			log.Fatalf("Copy stopped, %s is incomplete", dest)
+		}
 	},
 }
@@ -0,0 +1,111 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fwang2/pi/fs"
+	"github.com/fwang2/pi/util"
+	"github.com/spf13/cobra"
+)
+
+var diffc = &fs.DiffControl{}
+var diffFormat string
+
+func init() {
+	diffCmd.Flags().IntVarP(&diffc.TopN, "dirs", "d", 10, "top N directories by growth")
+	diffCmd.Flags().IntVar(&diffc.Depth, "depth", 0, "only rank directories up to this depth below the root")
+	diffCmd.Flags().BoolVar(&diffc.List, "list", false, "list every changed file")
+	diffCmd.Flags().StringVar(&diffFormat, "format", "text", "Output format: text or json")
+	rootCmd.AddCommand(diffCmd)
+}
+
+// signedByte ... ShortByte with an explicit sign
+func signedByte(v int64) string {
+	if v < 0 {
+		return "-" + util.ShortByte(-v)
+	}
+	return "+" + util.ShortByte(v)
+}
+
+// signedComma ... Comma with an explicit sign
+func signedComma(v int64) string {
+	if v < 0 {
+		return "-" + util.Comma(-v)
+	}
+	return "+" + util.Comma(v)
+}
+
+var diffMark = map[string]string{
+	fs.DIFF_ADDED:    "+",
+	fs.DIFF_REMOVED:  "-",
+	fs.DIFF_GROWN:    ">",
+	fs.DIFF_SHRUNK:   "<",
+	fs.DIFF_MODIFIED: "M",
+}
+
+func printDiff(ds *fs.DiffStat) {
+	fmt.Printf("\nChanges of %s\n", ds.Root)
+	fmt.Printf("from %v\nto   %v\n\n", ds.Old.Format("2006-01-02 15:04:05"), ds.New.Format("2006-01-02 15:04:05"))
+
+	w := tabwriter.NewWriter(os.Stdout, 8, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "\tFiles\tSize\t\n")
+	fmt.Fprintf(w, "Added\t%s\t%s\t\n", util.Comma(ds.Added.Files), signedByte(ds.Added.Bytes))
+	fmt.Fprintf(w, "Removed\t%s\t%s\t\n", util.Comma(ds.Removed.Files), signedByte(-ds.Removed.Bytes))
+	fmt.Fprintf(w, "Grown\t%s\t%s\t\n", util.Comma(ds.Grown.Files), signedByte(ds.Grown.Bytes))
+	fmt.Fprintf(w, "Shrunk\t%s\t%s\t\n", util.Comma(ds.Shrunk.Files), signedByte(-ds.Shrunk.Bytes))
+	fmt.Fprintf(w, "Modified\t%s\t\t\n", util.Comma(ds.Modified.Files))
+	fmt.Fprintf(w, "Net\t%s inodes\t%s\t\n", signedComma(ds.Inodes), signedByte(ds.Bytes))
+	w.Flush()
+
+	fmt.Printf("\n\nTop directories by growth\n\n")
+	for _, d := range ds.TopDirs {
+		fmt.Printf("\t%s (%s, %s inodes) \n", d.Path, signedByte(d.Bytes), signedComma(d.Inodes))
+	}
+	fmt.Printf("\n")
+
+	if len(ds.Changes) != 0 {
+		fmt.Printf("\nChanged files\n\n")
+		for _, c := range ds.Changes {
+			fmt.Printf("%s %s (%s)\n", diffMark[c.Kind], c.Path, signedByte(c.NewSize-c.OldSize))
+		}
+		fmt.Printf("\n")
+	}
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff old.pidx new.pidx",
+	Short: "Show what changed between two scan snapshots",
+	Long: `Compare two indexes of the same root, as written by "pi index build".
+
+Files are reported as added, removed, grown, shrunk, or modified when
+only their modification time changed. Directory deltas include their
+subdirectories.
+
+The two indexes are read side by side. Memory holds the entries one scan
+reached and the other not yet, plus the added and removed ones, so it grows
+with the amount of change and with how differently the scans were ordered,
+not with the size of the tree.`,
+	Args: cobra.ExactArgs(2),
+	Run: func(cmd *cobra.Command, args []string) {
+		if diffFormat != "text" && diffFormat != "json" {
+			log.Fatalf("Unknown --format: %s. Must be one of {text, json}", diffFormat)
+		}
+		ds, err := fs.DiffIndex(diffc, args[0], args[1])
+		if err != nil {
+			log.Fatalf("Can't diff: %v", err)
+		}
+		if diffFormat == "json" {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.SetEscapeHTML(false)
+			if err := enc.Encode(ds); err != nil {
+				log.Fatalf("Can't write report: %v", err)
+			}
+			return
+		}
+		printDiff(ds)
+	},
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/fwang2/pi/fs"
+	"github.com/fwang2/pi/util"
+	"github.com/spf13/cobra"
+)
+
+var duMaxDepth int
+var duApparent bool
+var duAllocated bool
+var duSort string
+var duIndex string
+var duLinks bool
+var duXDev bool
+var duFollow bool
+var duExclude excludeOpts
+
+func init() {
+	duCmd.Flags().IntVarP(&duMaxDepth, "max-depth", "d", 1, "Show directories this deep below the root, -1 for all")
+	duCmd.Flags().BoolVar(&duApparent, "apparent", false, "Use apparent sizes, as reported by stat")
+	duCmd.Flags().BoolVar(&duAllocated, "allocated", false, "Use allocated sizes, in 512B blocks")
+	duCmd.Flags().StringVar(&duSort, "sort", "size", "Sort by size or inodes")
+	duCmd.Flags().BoolVarP(&duFollow, "follow", "L", false, "Follow symbolic links")
+	duCmd.Flags().BoolVar(&duXDev, "xdev", false, "Don't descend directories on other file systems")
+	duCmd.Flags().BoolVarP(&duLinks, "count-links", "l", false, "Count sizes many times if hard linked")
+	duCmd.Flags().StringVar(&duIndex, "from-index", "", "Read the tree from an index instead of walking it")
+	duExclude.addFlags(duCmd)
+	rootCmd.AddCommand(duCmd)
+}
+
+func printDu(list []fs.DirNode) {
+	w := tabwriter.NewWriter(os.Stdout, 8, 8, 2, ' ', 0)
+	fmt.Fprintf(w, "Size\tInodes\tPath\n")
+	for _, n := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", util.ShortByte(n.Bytes), util.Comma(n.Inodes), n.Path)
+	}
+	w.Flush()
+}
+
+var duCmd = &cobra.Command{
+	Use:   "du [path]",
+	Short: "Parallel disk usage of directory subtrees",
+	Long: `Parallel disk usage of directory subtrees.
+
+Sizes are those of regular files below each directory. By default a
+file counts for its allocated size, but no more than its apparent size,
+as in "pi profile"; --apparent and --allocated pick one of the two.
+Inodes are the entries of any type below each directory.
+
+As GNU du, a file with several hard links is counted once, in the
+first directory it is found, unless --count-links is given.`,
+	Args: cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if duApparent && duAllocated {
+			log.Fatalf("--apparent and --allocated can't be used together")
+		}
+		if duSort != "size" && duSort != "inodes" {
+			log.Fatalf("Unknown --sort: %s. Must be one of {size, inodes}", duSort)
+		}
+
+		var ws *fs.WalkStat = new(fs.WalkStat)
+		ws.NumOfWorkers = NumOfWorkers
+		var wc *fs.WalkControl = new(fs.WalkControl)
+		wc.Verbose = Verbose
+		wc.DoTree = true
+		wc.CountLinks = duLinks
+		wc.XDev = duXDev
+		wc.Follow = duFollow
+		switch {
+		case duApparent:
+			wc.SizeMode = fs.SIZE_APPARENT
+		case duAllocated:
+			wc.SizeMode = fs.SIZE_ALLOCATED
+		}
+		wc.FromIndex = duIndex
+		if wc.FromIndex != "" {
+			ws.RootPath = indexRootPath(args)
+		} else {
+			ws.RootPath = fs.ParseRootPath(args)
+		}
+
+		wc.Exclude = duExclude.excluder(ws.RootPath)
+
+		handleInterrupt(wc)
+		runWalk(wc, ws)
+		printPartial(ws)
+		printDu(ws.Tree.Entries(duMaxDepth, duSort == "inodes"))
+		printLinks(ws)
+		printMounts(ws)
+		printBadLinks(ws, false)
+	},
+}
@@ -13,177 +13,217 @@ package cmd
 // find / -size +1G -exec rm  	find and delete
 // find / -mtime 50		find files modified 50 days back
 // find / -atime 50 		find files are accessed 50 days back
-// find / -mtime 50-100		find modified in between 50 to 100 days back
+// find / -mtime 50d..100d	find modified in between 50 to 100 days back
+// find / -atime 90d -mtime 180d	not accessed in 90 days and not modified in 180 days
 // find / -cmin -60		find changed file in last 1 hour
 // find / -mmin -60		find modified file in last 1 hour
 // find / -amin -60		find access files in last 1 hour
 // find / -exec save		save found
 
 import (
-	"regexp"
+	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/fwang2/pi/fs"
-	"github.com/fwang2/pi/util"
 	"github.com/spf13/cobra"
 )
 
 //var log = util.NewLogger()
 
 var findc = &fs.FindControl{}
-
-var fname string
-var fsize string
-var ftype string
-var apparent bool
-var delete bool
-var atime string
-var ctime string
-var mtime string
-
-var f_map = map[string]bool{
-	fs.F_FILE: true, // file
-	fs.F_DIR:  true, // directory
-	//fs.F_SOCKET:  true, // socket
-	fs.F_SYMLINK: true, // sym link
-	//fs.F_CHAR:    true, // char special
-	//fs.F_PIPE:    true, // pipe
-	//fs.F_BLOCK:   true, // block special
-}
+var findIndex string
+var findXDev bool
+var findFollow bool
+var findExclude excludeOpts
 
 func init() {
-	findCmd.Flags().StringVar(&fname, "name", "", "On name pattern")
-	findCmd.Flags().StringVar(&fsize, "size", "", "On file size")
-	findCmd.Flags().BoolVar(&apparent, "apparent", false, "Use apparent size")
-	findCmd.Flags().StringVar(&ftype, "type", "", "On file type")
-	findCmd.Flags().StringVar(&atime, "atime", "", "Access time (e.g 4h30m)")
-	findCmd.Flags().StringVar(&ctime, "ctime", "", "Creation time (e.g 4h30m)")
-	findCmd.Flags().StringVar(&mtime, "mtime", "", "Modification time (e.g 4h30m)")
-	findCmd.Flags().BoolVar(&delete, "delete", false, "delete files")
+	// The expression is parsed by fs.ParseExpr, as cobra can't keep
+	// the order of flags. These are registered for the help output only.
+	findCmd.Flags().String("name", "", "On name pattern")
+	findCmd.Flags().String("size", "", "On file size")
+	findCmd.Flags().Bool("apparent", false, "Use apparent size")
+	findCmd.Flags().String("type", "", "On file type")
+	findCmd.Flags().String("atime", "", "Access time (e.g 4h30m, -7d, 30d..90d)")
+	findCmd.Flags().String("ctime", "", "Status change time (e.g 4h30m, -7d, 30d..90d)")
+	findCmd.Flags().String("mtime", "", "Modification time (e.g 4h30m, -7d, 30d..90d)")
+	findCmd.Flags().String("btime", "", "Birth time, where the file system keeps it (e.g 4h30m, -7d, 30d..90d)")
+	findCmd.Flags().String("user", "", "Owned by user name or uid")
+	findCmd.Flags().String("uid", "", "Owned by uid")
+	findCmd.Flags().String("group", "", "Owned by group name or gid")
+	findCmd.Flags().String("gid", "", "Owned by gid")
+	findCmd.Flags().Bool("nouser", false, "Owner is not in the user database")
+	findCmd.Flags().Bool("broken-links", false, "Symlinks whose target doesn't exist, or loop")
+	findCmd.Flags().String("perm", "", "Permission bits: exact (644), all of (-022) or any of (/022)")
+	findCmd.Flags().Bool("delete", false, "delete files")
+	findCmd.Flags().BoolP("follow", "L", false, "Follow symbolic links")
+	findCmd.Flags().Bool("xdev", false, "Don't descend directories on other file systems")
+	findCmd.Flags().String("from-index", "", "Search an index instead of the file system")
+	findExclude.addFlags(findCmd)
 
 	rootCmd.AddCommand(findCmd)
 }
 
 var findCmd = &cobra.Command{
-	Use:   "find",
+	Use:   "find path [expression]",
 	Short: "A subset of Unix find-alike functions",
-	Args:  cobra.ExactArgs(1),
-	Run: func(cmd *cobra.Command, args []string) {
+	Long: `A subset of Unix find-alike functions.
 
-		// validate flags
-		if fname != "" {
-			findc.Name = fname
-			findc.Flags = fs.Set(findc.Flags, fs.FB_NAME)
-		}
-		if fsize != "" {
-			parse_fsize(fsize)
-			findc.Flags = fs.Set(findc.Flags, fs.FB_SIZE)
-			findc.Apparent = apparent
-		}
+Primaries can be combined as in GNU find:
 
-		if delete {
-			findc.DeleteFlag = true
+  ( expr )     group
+  ! expr       negate, also --not
+  expr expr    and, also -a or --and
+  expr -o expr or, also --or
+
+e.g. pi find /path \( --name '*.h5' -o --name '*.nc' \) ! --type d`,
+	DisableFlagParsing: true,
+	Run: func(cmd *cobra.Command, args []string) {
+		root, tokens, help, err := parse_find_args(args)
+		if help {
+			cmd.Help()
+			return
+		}
+		if err != nil {
+			log.Fatal(err)
 		}
 
-		if ftype != "" {
-			parse_ftype(ftype)
-		} else {
-			// if not ftype specified, default it f
-			findc.Flags = fs.Set(findc.Flags, fs.FB_TYPE_F)
-			//findc.Flags = fs.Set(findc.Flags, fs.FB_TYPE_D)
-			//findc.Flags = fs.Set(findc.Flags, fs.FB_TYPE_L)
+		findc.Expr, err = fs.ParseExpr(findc, tokens)
+		if err != nil {
+			log.Fatal(err)
 		}
-		parse_time()
 
 		log.Debugf("findc.Flags = %b", findc.Flags)
 		// Determine path
 		var ws *fs.WalkStat = new(fs.WalkStat)
 		ws.NumOfWorkers = NumOfWorkers
-		ws.RootPath = fs.ParseRootPath(args)
-		ws.NumOfWorkers = NumOfWorkers
 		var wc *fs.WalkControl = new(fs.WalkControl)
+		wc.Verbose = Verbose
 		wc.DoProgress = false
 		wc.Findc = findc
-		fs.RunProfile(wc, ws)
+		wc.FromIndex = findIndex
+		wc.XDev = findXDev
+		wc.Follow = findFollow
+		if wc.FromIndex != "" {
+			if findc.DeleteFlag {
+				log.Fatal("--delete can't be used with --from-index")
+			}
+			ws.RootPath = indexRootPath([]string{root})
+		} else {
+			ws.RootPath = fs.ParseRootPath([]string{root})
+		}
+		wc.Exclude = findExclude.excluder(ws.RootPath)
+		// entries are lstat'ed only if the expression asks for more
+		// than names and types, or to sum up the sizes of directories
+		wc.NoTally = !findc.DirSizes()
+		handleInterrupt(wc)
+		runWalk(wc, ws)
+		for _, l := range ws.LoopLinks {
+			log.Warnf("File system loop, not followed: %s", l)
+		}
+		if ws.Partial {
+			log.Warnf("The search was interrupted, matches may be missing")
+		}
 	},
 }
 
-func parse_time() {
-	// for now, we only handle time util now.
-	var t string
-	if atime != "" {
-		t = atime
-		findc.Flags = fs.Set(findc.Flags, fs.FB_ATIME)
-	} else if mtime != "" {
-		t = mtime
-		findc.Flags = fs.Set(findc.Flags, fs.FB_MTIME)
-	} else if ctime != "" {
-		t = ctime
-		findc.Flags = fs.Set(findc.Flags, fs.FB_CTIME)
-	} else {
-		return
-	}
-
-	du, neg, err := util.ParseDuration(t)
-	if err != nil {
-		log.Fatalf("Wrong time format: %s", t)
-	}
-	if neg {
-		findc.EndTime = time.Now()
-		findc.StartTime = findc.EndTime.Add(-du)
-	} else {
-		findc.StartTime = time.Unix(0, 0)
-		findc.EndTime = time.Now().Add(-du)
-	}
-
-	log.Debugf("start_time = %v, end_time = %v", findc.StartTime, findc.EndTime)
-
-}
-func parse_ftype(s string) {
-	if !f_map[s] {
-		log.Fatalf("can't parse file type: %s. Must be one of {dfl}", s)
+// parse_find_args ... split the command line into the root path and the
+// expression tokens, handling the options that are not part of the
+// expression on the way.
+func parse_find_args(args []string) (root string, tokens []string, help bool, err error) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "-h" || arg == "--help":
+			help = true
+			return
+		case arg == "--delete":
+			findc.DeleteFlag = true
+		case arg == "--xdev":
+			findXDev = true
+		case arg == "-L" || arg == "--follow":
+			findFollow = true
+		case arg == "--apparent":
+			findc.Apparent = true
+		case arg == "-v" || arg == "--verbose":
+			Verbose = true
+		case is_option(arg, "--np"):
+			var val string
+			if val, err = option_value(args, &i, "--np"); err != nil {
+				return
+			}
+			if NumOfWorkers, err = strconv.Atoi(val); err != nil {
+				err = fmt.Errorf("can't parse --np: %s", val)
+				return
+			}
+		case is_option(arg, "--timeout"):
+			var val string
+			if val, err = option_value(args, &i, "--timeout"); err != nil {
+				return
+			}
+			if Timeout, err = time.ParseDuration(val); err != nil {
+				err = fmt.Errorf("can't parse --timeout: %s", val)
+				return
+			}
+		case is_option(arg, "--from-index"):
+			if findIndex, err = option_value(args, &i, "--from-index"); err != nil {
+				return
+			}
+		case is_option(arg, "--exclude"):
+			var val string
+			if val, err = option_value(args, &i, "--exclude"); err != nil {
+				return
+			}
+			findExclude.globs = append(findExclude.globs, val)
+		case is_option(arg, "--exclude-regex"):
+			var val string
+			if val, err = option_value(args, &i, "--exclude-regex"); err != nil {
+				return
+			}
+			findExclude.regexes = append(findExclude.regexes, val)
+		case is_option(arg, "--exclude-from"):
+			var val string
+			if val, err = option_value(args, &i, "--exclude-from"); err != nil {
+				return
+			}
+			findExclude.files = append(findExclude.files, val)
+		case fs.IsExprToken(arg):
+			tokens = append(tokens, arg)
+			// primary argument may look like an option, e.g. --mtime -7d
+			if fs.TakesArg(arg) && !strings.Contains(arg, "=") && i+1 < len(args) {
+				i++
+				tokens = append(tokens, args[i])
+			}
+		case root == "" && len(tokens) == 0 && !strings.HasPrefix(arg, "-"):
+			root = arg
+		default:
+			err = fmt.Errorf("unknown argument: %s", arg)
+			return
+		}
 	}
-	switch s {
-	case fs.F_DIR:
-		findc.Flags = fs.Set(findc.Flags, fs.FB_TYPE_D)
-	case fs.F_FILE:
-		findc.Flags = fs.Set(findc.Flags, fs.FB_TYPE_F)
-	case fs.F_SYMLINK:
-		findc.Flags = fs.Set(findc.Flags, fs.FB_TYPE_L)
+	// the index knows its root
+	if root == "" && findIndex == "" {
+		err = fmt.Errorf("requires a path to search")
 	}
+	return
 }
 
-func parse_fsize(s string) {
-	// 4k, -4k, +4k are valid
-	// re := regexp.MustCompile(`(?P<x>\+|\-)?(?P<y>[[:digit:]]+)(?P<z>(c|C|k|K|m|M|g|G|t|T)?)`)
-	re := regexp.MustCompile(`(\+|\-)?([[:digit:]]+)(c|C|k|K|m|M|g|G|t|T)?`)
-	out := re.FindStringSubmatch(s)
-	if len(out) == 0 {
-		log.Fatalf("Can't parse --size: %s", fsize)
-	}
-
-	// DEBU[0000] -size: [+400k + 400 k], length=4
-	// DEBU[0000] 	 k=0, v=+400k
-	// DEBU[0000] 	 k=1, v=+
-	// DEBU[0000] 	 k=2, v=400
-	// DEBU[0000] 	 k=3, v=k
-
-	log.Debugf("-size: %v, length=%d", out, len(out))
+// is_option ... true if arg is the option name, with or without "=value"
+func is_option(arg string, name string) bool {
+	return arg == name || strings.HasPrefix(arg, name+"=")
+}
 
-	if out[1] == "+" {
-		findc.SizeOp = fs.GREAT_THAN
-	} else if out[1] == "-" {
-		findc.SizeOp = fs.LESS_THAN
-	} else {
-		findc.SizeOp = fs.EQUAL
+// option_value ... the value of an option given as "name=value", or as
+// "name value" in which case *i is moved past the value
+func option_value(args []string, i *int, name string) (string, error) {
+	arg := args[*i]
+	if arg != name {
+		return strings.TrimPrefix(arg, name+"="), nil
 	}
-
-	// no unit is given, default to c
-	if out[3] == "" {
-		out[3] = "c"
+	if *i+1 == len(args) {
+		return "", fmt.Errorf("missing argument to %s", name)
 	}
-
-	findc.Size = util.StrBytes(out[2] + out[3])
-	findc.Flags = fs.Set(findc.Flags, fs.FB_SIZE)
-	log.Debugf("fsize threshold = %d\n", findc.Size)
+	*i++
+	return args[*i], nil
 }
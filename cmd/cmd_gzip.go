@@ -15,9 +15,11 @@ import (
 )
 
 var zipname string
+var zipExclude excludeOpts
 
 func init() {
 	gzipCmd.Flags().StringVarP(&zipname, "output", "o", "", "output file")
+	zipExclude.addFlags(gzipCmd)
 	rootCmd.AddCommand(gzipCmd)
 }
 
@@ -85,12 +87,19 @@ func zipDir(src string) error {
 		os.Exit(1)
 	}
 
+	exclude := zipExclude.excluder(filepath.Clean(src))
 	err = filepath.Walk(src,
 		func(file string, fi os.FileInfo, err error) error {
 			if err != nil {
 				log.Warnf("prevent panic by handling failure accessing a path %q: %v\n", file, err)
 				return err
 			}
+			if file != src && exclude.Excluded(file, fi.IsDir()) {
+				if fi.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
 			if !fi.Mode().IsRegular() {
 				return nil
 			}
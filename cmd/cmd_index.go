@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/fwang2/pi/fs"
+	"github.com/fwang2/pi/util"
+	"github.com/spf13/cobra"
+)
+
+var indexOutput string
+var indexAppend bool
+var indexXDev bool
+var indexExclude excludeOpts
+
+func init() {
+	indexBuildCmd.Flags().StringVarP(&indexOutput, "output", "o", "scan.pidx", "Index file to write")
+	indexBuildCmd.Flags().BoolVar(&indexAppend, "append", false, "Append to an existing index")
+	indexBuildCmd.Flags().BoolVar(&indexXDev, "xdev", false, "Don't descend directories on other file systems")
+	indexExclude.addFlags(indexBuildCmd)
+	indexCmd.AddCommand(indexBuildCmd)
+	rootCmd.AddCommand(indexCmd)
+}
+
+// indexRootPath ... the root of a walk read from an index. It is
+// resolved but not checked, the tree may not be mounted here. An empty
+// root means the root of the index.
+func indexRootPath(args []string) string {
+	if len(args) == 0 || args[0] == "" {
+		return ""
+	}
+	root, err := filepath.Abs(args[0])
+	if err != nil {
+		log.Fatalf("Can't resolve %s: %v", args[0], err)
+	}
+	return root
+}
+
+// runWalk ... walk the tree, or read it back from wc.FromIndex
+func runWalk(wc *fs.WalkControl, ws *fs.WalkStat) {
+	if wc.FromIndex == "" {
+		fs.RunProfile(wc, ws)
+		return
+	}
+	if wc.DoSparse || wc.SniffExt {
+		log.Fatalf("--sparse and --sniff read the files, they can't be used with --from-index")
+	}
+	if wc.XDev {
+		log.Fatalf("--xdev can't be used with --from-index, build the index with --xdev instead")
+	}
+	if wc.Follow {
+		log.Fatalf("--follow can't be used with --from-index, the index has the links only")
+	}
+	if err := fs.RunIndex(wc, ws); err != nil {
+		log.Fatalf("Can't read index: %v", err)
+	}
+}
+
+var indexCmd = &cobra.Command{
+	Use:   "index",
+	Short: "Manage scan snapshots",
+	Long: `An index records the metadata of every entry of a tree, so that
+profile, topn and find can run later with --from-index, without
+walking the file system again.`,
+}
+
+var indexBuildCmd = &cobra.Command{
+	Use:   "build <root>",
+	Short: "Walk a tree and save it as an index",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		root, err := filepath.Abs(fs.ParseRootPath(args))
+		if err != nil {
+			log.Fatalf("Can't resolve root: %v", err)
+		}
+
+		var ws *fs.WalkStat = new(fs.WalkStat)
+		ws.NumOfWorkers = NumOfWorkers
+		ws.RootPath = root
+		var wc *fs.WalkControl = new(fs.WalkControl)
+		wc.Verbose = Verbose
+		wc.DoProgress = true
+		wc.XDev = indexXDev
+		wc.Exclude = indexExclude.excluder(root)
+		wc.Index, err = fs.CreateIndex(indexOutput, root, NumOfWorkers, indexAppend)
+		if err != nil {
+			log.Fatalf("Can't create index: %v", err)
+		}
+
+		handleInterrupt(wc)
+		fs.WalkPrologue(ws)
+		start := time.Now()
+		fs.RunProfile(wc, ws)
+		fs.CalcRate(start, ws)
+		if err := wc.Index.Close(); err != nil {
+			log.Fatalf("Can't write index: %v", err)
+		}
+
+		printPartial(ws)
+		if ws.Partial {
+			fmt.Printf("The index is marked partial, pi diff won't compare it\n")
+		}
+		fmt.Printf("\nIndexed %s files, %s dirs (%s) into %s in %v\n\n",
+			util.Comma(ws.TotFileCnt), util.Comma(ws.TotDirCnt),
+			util.ShortByte(ws.TotFileSize), indexOutput, ws.Elapsed)
+		printMounts(ws)
+	},
+}
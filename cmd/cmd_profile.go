@@ -3,7 +3,7 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"strings"
+	"path/filepath"
 	"text/tabwriter"
 	"time"
 
@@ -14,44 +14,52 @@ import (
 
 var ws *fs.WalkStat = new(fs.WalkStat)
 var wc *fs.WalkControl = new(fs.WalkControl)
+var format string
+var resume string
+var htmlReport string
+var ageHist string
+var ageBins string
+var profileTopN int
+var profileExclude excludeOpts
+var listLinks bool
+var checkLinks bool
+
+// asked ... the options of the text, JSON or CSV report, without what
+// --html turns on for its own
+var asked fs.WalkControl
 
 func init() {
 
 	profileCmd.Flags().BoolVar(&wc.DoHist, "hist", false, "Do histogram")
+	profileCmd.Flags().StringVar(&ageHist, "age-hist", "", "Do file age histogram on atime, mtime or ctime")
+	profileCmd.Flags().StringVar(&ageBins, "age-bins", "1d,7d,30d,90d,180d,1y,3y", "age histogram bins")
 	profileCmd.Flags().BoolVar(&wc.DoSparse, "sparse", false, "Check sparse file")
+	profileCmd.Flags().BoolVarP(&wc.Follow, "follow", "L", false, "Follow symbolic links")
+	profileCmd.Flags().BoolVar(&checkLinks, "check-links", false, "Count the broken, looping and out of root symlinks")
+	profileCmd.Flags().BoolVar(&listLinks, "list-links", false, "List the broken, looping and out of root symlinks, implies --check-links")
+	profileCmd.Flags().BoolVar(&wc.XDev, "xdev", false, "Don't descend directories on other file systems")
+	profileCmd.Flags().BoolVarP(&wc.CountLinks, "count-links", "l", false, "Count sizes many times if hard linked")
+	profileCmd.Flags().BoolVar(&wc.DoByUser, "by-user", false, "Usage by user")
+	profileCmd.Flags().BoolVar(&wc.DoByGroup, "by-group", false, "Usage by group")
+	profileCmd.Flags().BoolVar(&wc.DoByExt, "by-ext", false, "Usage by file extension")
+	profileCmd.Flags().BoolVar(&wc.SniffExt, "sniff", false, "With --by-ext, classify files without extension by content")
+	profileCmd.Flags().IntVar(&wc.TopExts, "ext-top", 20, "Number of extensions shown, the rest is summed up as other")
+	profileCmd.Flags().StringVar(&wc.Checkpoint, "checkpoint", "", "Periodically save progress to file")
+	profileCmd.Flags().DurationVar(&wc.CheckpointInterval, "checkpoint-interval", 5*time.Minute, "How often to save progress")
+	profileCmd.Flags().StringVar(&resume, "resume", "", "Continue from a checkpoint file")
+	profileCmd.Flags().StringVar(&format, "format", "text", "Output format: text, json or csv")
+	profileCmd.Flags().StringVar(&htmlReport, "html", "", "Also write a self-contained HTML report to file")
+	profileCmd.Flags().IntVar(&profileTopN, "top", 10, "Number of files and directories listed in the HTML report")
+	profileCmd.Flags().StringVar(&wc.FromIndex, "from-index", "", "Read the tree from an index instead of walking it")
 	var bins = topnCmd.Flags().String("bins",
 		"4k,8k,16k,32k,64k,256k,512k,1m,4m,16m,512m,1g,16g,64g,128g,256g,1t,32t", "histogram bins")
 	ws.HistBins = util.BinsToNum(*bins)
 	ws.HistCounter = make([]int64, len(ws.HistBins), len(ws.HistBins))
 
-	// check exclusions
-
-	wc.ExcludeMap = map[string]bool{
-		"/Volumes/GoogleDrive": true,
-		"/Volumes/Recovery":    true,
-	}
-
-	// check env PI_EXCLUDE
-	checkExcludeEnv()
-
-	log.Debug("Exclusion:", wc.ExcludeMap)
-
+	profileExclude.addFlags(profileCmd)
 	rootCmd.AddCommand(profileCmd)
 }
 
-func checkExcludeEnv() {
-	exstring := os.Getenv("PI_EXCLUDE")
-	if exstring == "" {
-		return
-	}
-	expath := strings.Split(exstring, ":")
-	for _, v := range expath {
-		if !wc.ExcludeMap[v] {
-			wc.ExcludeMap[v] = true
-		}
-	}
-}
-
 func printHistogram() {
 	fmt.Printf("\nHistogram\n\n")
 	// minwidth, tabwidth, padding, padchar
@@ -76,22 +84,147 @@ func printHistogram() {
 	fmt.Println()
 }
 
+func printAgeHistogram() {
+	fmt.Printf("\nAge histogram (%s)\n\n", fs.AgeFieldName(wc.AgeField))
+	w := tabwriter.NewWriter(os.Stdout, 8, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Age\tFiles\t%% Files\tSize\t%% Size\t\n")
+	for i := range ws.AgeFiles {
+		var pf, pb float64
+		if ws.TotFileCnt != 0 {
+			pf = float64(ws.AgeFiles[i]) / float64(ws.TotFileCnt) * 100
+		}
+		if ws.TotFileSize != 0 {
+			pb = float64(ws.AgeBytes[i]) / float64(ws.TotFileSize) * 100
+		}
+		fmt.Fprintf(w, "%s\t%s\t%.2f%%\t%s\t%.2f%%\t\n",
+			fs.AgeLabel(ws.AgeBins, i), util.Comma(ws.AgeFiles[i]), pf,
+			util.ShortByte(ws.AgeBytes[i]), pb)
+	}
+	w.Flush()
+	fmt.Println()
+}
+
+func printUsage(title string, list []fs.OwnerUsage) {
+	fmt.Printf("\n%s\n\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 8, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Name\tFiles\tSize\tAllocated\t%% Allocated\t\n")
+	var total int64
+	for _, u := range list {
+		total += u.Allocated
+	}
+	for _, u := range list {
+		var pct float64
+		if total != 0 {
+			pct = float64(u.Allocated) / float64(total) * 100
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.2f%%\t\n",
+			u.Name, util.Comma(u.Files), util.ShortByte(u.Bytes),
+			util.ShortByte(u.Allocated), pct)
+	}
+	w.Flush()
+	fmt.Println()
+}
+
+func printExtUsage(list []fs.ExtUsage) {
+	fmt.Printf("\nUsage by extension\n\n")
+	w := tabwriter.NewWriter(os.Stdout, 8, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(w, "Extension\tFiles\tSize\tAllocated\t%% Allocated\t\n")
+	var total int64
+	for _, e := range list {
+		total += e.Allocated
+	}
+	for _, e := range list {
+		var pct float64
+		if total != 0 {
+			pct = float64(e.Allocated) / float64(total) * 100
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%.2f%%\t\n",
+			e.Ext, util.Comma(e.Files), util.ShortByte(e.Bytes),
+			util.ShortByte(e.Allocated), pct)
+	}
+	w.Flush()
+	fmt.Println()
+}
+
+// printLinks ... a note on the files counted once, for du and topn
+func printLinks(ws *fs.WalkStat) {
+	if ws.TotLinkCnt != 0 {
+		fmt.Printf("\n%s extra links to files counted once, %s not counted again (-l to count them)\n",
+			util.Comma(ws.TotLinkCnt), util.ShortByte(ws.TotLinkSize))
+	}
+}
+
+// printMounts ... the mount points left out by --xdev
+func printMounts(ws *fs.WalkStat) {
+	if len(ws.Mounts) == 0 {
+		return
+	}
+	fmt.Printf("\nSkipped %d mount points (--xdev)\n\n", len(ws.Mounts))
+	for _, m := range ws.Mounts {
+		fmt.Printf("\t%s\n", m)
+	}
+	fmt.Println()
+}
+
+// printBadLinks ... the symlinks that can't be followed, or lead out
+// of the root, the first 10 of each kind unless all
+func printBadLinks(ws *fs.WalkStat, all bool) {
+	if len(ws.BrokenLinks)+len(ws.LoopLinks)+len(ws.OutsideLinks) == 0 {
+		return
+	}
+	for _, l := range []struct {
+		title string
+		list  []string
+	}{
+		{"Broken symlinks", ws.BrokenLinks},
+		{"Looping symlinks", ws.LoopLinks},
+		{"Symlinks out of the root", ws.OutsideLinks},
+	} {
+		if len(l.list) == 0 {
+			continue
+		}
+		fmt.Printf("\n%s (%s)\n\n", l.title, util.Comma(int64(len(l.list))))
+		for i, p := range l.list {
+			if i == 10 && !all {
+				fmt.Printf("\t... and %d more\n", len(l.list)-i)
+				break
+			}
+			fmt.Printf("\t%s\n", p)
+		}
+	}
+	fmt.Println()
+}
+
 func printSummary() {
 	const padding = 10
 	w := tabwriter.NewWriter(os.Stdout, 0, 0, padding, '.', tabwriter.Debug)
 	fmt.Fprintf(w, "Total # of files \t %s\n", util.Comma(ws.TotFileCnt))
 	fmt.Fprintf(w, "Total # of dirs \t %s\n", util.Comma(ws.TotDirCnt))
 	fmt.Fprintf(w, "Total # of symlinks \t %s\n", util.Comma(ws.TotSymlinkCnt))
+	if wc.CheckLinks {
+		fmt.Fprintf(w, "Broken / looping / out of root symlinks \t %s / %s / %s\n",
+			util.Comma(int64(len(ws.BrokenLinks))), util.Comma(int64(len(ws.LoopLinks))),
+			util.Comma(int64(len(ws.OutsideLinks))))
+	}
 	fmt.Fprintf(w, "Total # of pipes \t %s\n", util.Comma(ws.TotPipeCnt))
 	if wc.DoSparse {
 		fmt.Fprintf(w, "Total # of sparse files \t %s\n", util.Comma(ws.TotSparseCnt))
 	}
-	fmt.Fprintf(w, "Avg file size \t %s\n", util.ShortByte(ws.TotFileSize/ws.TotFileCnt))
+	if ws.TotFileCnt != 0 {
+		fmt.Fprintf(w, "Avg file size \t %s\n", util.ShortByte(ws.TotFileSize/ws.TotFileCnt))
+	}
 	if ws.TotDirCnt != 0 {
 		fmt.Fprintf(w, "Avg # of entries per directory \t %s\n", util.Comma(ws.TotFileCnt/ws.TotDirCnt))
 	}
 	fmt.Fprintf(w, "Aggregated file size \t %s\n", util.ShortByte(ws.TotFileSize))
+	if ws.TotLinkCnt != 0 {
+		fmt.Fprintf(w, "Extra links, not counted \t %s (%s)\n",
+			util.Comma(ws.TotLinkCnt), util.ShortByte(ws.TotLinkSize))
+	}
 	fmt.Fprintf(w, "Skipped \t %s\n", util.Comma(ws.TotSkipped))
+	if ws.TotExcluded != 0 {
+		fmt.Fprintf(w, "Excluded \t %s\n", util.Comma(ws.TotExcluded))
+	}
 	fmt.Fprintf(w, "Scanning rate \t %d/s \n", ws.Rate)
 	fmt.Fprintf(w, "Elapsed time \t %v\n\n", ws.Elapsed)
 
@@ -99,10 +232,87 @@ func printSummary() {
 }
 
 func profileEpilogue(ws *fs.WalkStat) {
-	if wc.DoHist {
-		printHistogram()
+	var err error
+	switch format {
+	case "json":
+		err = fs.NewReport(&asked, ws).WriteJSON(os.Stdout)
+	case "csv":
+		err = fs.NewReport(&asked, ws).WriteCSV(os.Stdout)
+	default:
+		printPartial(ws)
+		if asked.DoHist {
+			printHistogram()
+		}
+		if asked.DoAgeHist {
+			printAgeHistogram()
+		}
+		if asked.DoByUser {
+			printUsage("Usage by user", fs.UserUsage(ws))
+		}
+		if asked.DoByGroup {
+			printUsage("Usage by group", fs.GroupUsage(ws))
+		}
+		if asked.DoByExt {
+			printExtUsage(fs.TopExtUsage(ws, wc.TopExts))
+		}
+		printMounts(ws)
+		if listLinks || wc.Follow {
+			printBadLinks(ws, listLinks)
+		}
+		printSummary()
+	}
+	if err != nil {
+		log.Fatalf("Can't write report: %v", err)
 	}
-	printSummary()
+	if htmlReport != "" {
+		writeHTML(htmlReport)
+	}
+}
+
+// enableHTML ... turn on what the HTML report shows
+func enableHTML() {
+	wc.DoHist = true
+	wc.DoByUser = true
+	wc.DoByExt = true
+	wc.DoAgeHist = true
+	wc.TopNfiles = true
+	wc.DoTree = true
+	ws.TopNFileQ = util.NewSortedQueue(profileTopN)
+}
+
+func writeHTML(file string) {
+	f, err := os.Create(file)
+	if err != nil {
+		log.Fatalf("Can't write HTML report: %v", err)
+	}
+	err = fs.NewReport(wc, ws).WriteHTML(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatalf("Can't write HTML report: %v", err)
+	}
+	if format == "text" {
+		fmt.Printf("HTML report written to %s\n", file)
+	}
+}
+
+// loadResume ... the root comes from the checkpoint, and progress keeps
+// being saved to it unless --checkpoint says otherwise.
+func loadResume(args []string) {
+	cp, err := fs.LoadCheckpoint(resume)
+	if err != nil {
+		log.Fatalf("Can't resume: %v", err)
+	}
+	if len(args) != 0 && filepath.Clean(args[0]) != filepath.Clean(cp.Root) {
+		log.Fatalf("Checkpoint is for %s, not %s", cp.Root, args[0])
+	}
+	if wc.Checkpoint == "" {
+		wc.Checkpoint = resume
+	}
+	ws.RootPath = cp.Root
+	wc.Resume = cp
+	log.Debugf("Resuming %s with %d pending directories", cp.Root, len(cp.Pending))
 }
 
 var profileCmd = &cobra.Command{
@@ -110,15 +320,71 @@ var profileCmd = &cobra.Command{
 	Short: "General file system profiling",
 	Args:  cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
+		if format != "text" && format != "json" && format != "csv" {
+			log.Fatalf("Unknown --format: %s. Must be one of {text, json, csv}", format)
+		}
 		// Determine path
 		ws.NumOfWorkers = NumOfWorkers
-		ws.RootPath = fs.ParseRootPath(args)
+		if wc.FromIndex != "" {
+			if resume != "" || wc.Checkpoint != "" {
+				log.Fatalf("--from-index can't be used with --checkpoint or --resume")
+			}
+			ws.RootPath = indexRootPath(args)
+		} else {
+			ws.RootPath = fs.ParseRootPath(args)
+		}
+		// a checkpoint keeps neither the top files nor the tree
+		if htmlReport != "" && (resume != "" || wc.Checkpoint != "") {
+			log.Fatalf("--html can't be used with --checkpoint or --resume")
+		}
+		if resume != "" {
+			loadResume(args)
+		}
+		wc.Exclude = profileExclude.excluder(ws.RootPath)
+		// a stat and a resolve of every symlink, asked for only
+		wc.CheckLinks = checkLinks || listLinks
+		if wc.CheckLinks && wc.FromIndex != "" {
+			// the targets are on the live file system only
+			log.Fatalf("--check-links and --list-links can't be used with --from-index")
+		}
 		wc.TopNdirs = false
 		wc.TopNfiles = false
-		wc.DoProgress = true 
-		fs.WalkPrologue(ws)
+		if wc.SniffExt && !wc.DoByExt {
+			log.Fatalf("--sniff needs --by-ext")
+		}
+		if ageHist != "" {
+			var err error
+			if wc.AgeField, err = fs.ParseAgeField(ageHist); err != nil {
+				log.Fatal(err)
+			}
+			wc.DoAgeHist = true
+		}
+		if wc.Resume != nil {
+			// report on what the counters of the checkpoint hold
+			wc.Resume.RestoreOptions(wc)
+		}
+		asked = *wc
+		if htmlReport != "" {
+			enableHTML()
+		}
+		if wc.DoAgeHist {
+			var err error
+			if ws.AgeBins, err = util.BinsToDuration(ageBins); err != nil {
+				log.Fatalf("Can't parse --age-bins: %v", err)
+			}
+		}
+		// keep stdout clean for machine-readable output
+		wc.DoProgress = format == "text"
+		if format == "text" {
+			if wc.FromIndex != "" {
+				fs.IndexPrologue(wc)
+			} else {
+				fs.WalkPrologue(ws)
+			}
+		}
+		handleInterrupt(wc)
 		start := time.Now()
-		fs.RunProfile(wc, ws)
+		runWalk(wc, ws)
 		fs.CalcRate(start, ws)
 		profileEpilogue(ws)
 	},
@@ -11,16 +11,45 @@ import (
 
 var topNfiles int
 var topNdirs int
+var topnIndex string
+var topnLinks bool
+var topnXDev bool
+var topnFollow bool
+var topnExclude excludeOpts
 
 func init() {
 	topnCmd.Flags().IntVarP(&topNdirs, "dirs", "d", 5, "top N directories")
 	topnCmd.Flags().IntVarP(&topNfiles, "files", "f", 5, "top N files")
+	topnCmd.Flags().BoolVarP(&topnFollow, "follow", "L", false, "Follow symbolic links")
+	topnCmd.Flags().BoolVar(&topnXDev, "xdev", false, "Don't descend directories on other file systems")
+	topnCmd.Flags().BoolVarP(&topnLinks, "count-links", "l", false, "Count sizes many times if hard linked")
+	topnCmd.Flags().StringVar(&topnIndex, "from-index", "", "Read the tree from an index instead of walking it")
+	topnExclude.addFlags(topnCmd)
 	rootCmd.AddCommand(topnCmd)
 }
 
 func topnEpilogue(ws *fs.WalkStat) {
+	printPartial(ws)
 	printTopNdir(ws.TopNDirQ.Items())
+	printTopNtree("Top directories by size beneath them", ws.Tree.Top(topNdirs, false), false)
+	printTopNtree("Top directories by inodes beneath them", ws.Tree.Top(topNdirs, true), true)
 	printTopNfile(ws.TopNFileQ.Items())
+	printTopNatime(ws.TopNAtimeQ.Items())
+	printLinks(ws)
+	printMounts(ws)
+	printBadLinks(ws, false)
+}
+
+func printTopNtree(title string, list []fs.DirNode, byInodes bool) {
+	fmt.Printf("\n\n%s\n\n", title)
+	for _, n := range list {
+		if byInodes {
+			fmt.Printf("\t%s (%s) \n", n.Path, util.Comma(n.Inodes))
+		} else {
+			fmt.Printf("\t%s (%s) \n", n.Path, util.ShortByte(n.Bytes))
+		}
+	}
+	fmt.Printf("\n")
 }
 
 func printTopNdir(items util.ItemList) {
@@ -34,28 +63,63 @@ func printTopNdir(items util.ItemList) {
 func printTopNfile(items util.ItemList) {
 	fmt.Printf("\n\nTop count on large files\n\n")
 	for i := len(items) - 1; i >= 0; i-- {
-		fmt.Printf("\t%s (%s) \n", items[i].Name, util.ShortByte(items[i].Val))
+		fmt.Printf("\t%s (%s%s) \n", items[i].Name, util.ShortByte(items[i].Val), born(items[i]))
 	}
 	fmt.Printf("\n")
 }
 
+func printTopNatime(items util.ItemList) {
+	fmt.Printf("\n\nTop files by oldest access time\n\n")
+	for i := len(items) - 1; i >= 0; i-- {
+		atime := time.Unix(0, -items[i].Val)
+		fmt.Printf("\t%s (%s%s) \n", items[i].Name, atime.Format("2006-01-02 15:04:05"), born(items[i]))
+	}
+	fmt.Printf("\n")
+}
+
+// born ... the birth time of a file, where the file system keeps it
+func born(it util.Item) string {
+	if it.Time == 0 {
+		return ""
+	}
+	return ", born " + time.Unix(0, it.Time).Format("2006-01-02 15:04:05")
+}
+
 var topnCmd = &cobra.Command{
 	Use:   "topn",
 	Short: "Find top N items of interest",
-	Args:  cobra.MaximumNArgs(1),
+	Long: `Find top N items of interest, all from one walk:
+
+  directories by number of entries, by size and by inodes beneath them,
+  files by size and by oldest access time`,
+	Args: cobra.MaximumNArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		// Determine path
 		var ws *fs.WalkStat = new(fs.WalkStat)
 		ws.NumOfWorkers = NumOfWorkers
-		ws.RootPath = fs.ParseRootPath(args)
 		ws.TopNDirQ = util.NewSortedQueue(topNdirs)
 		ws.TopNFileQ = util.NewSortedQueue(topNfiles)
+		ws.TopNAtimeQ = util.NewSortedQueue(topNfiles)
 		var wc *fs.WalkControl = new(fs.WalkControl)
 		wc.TopNdirs = true
 		wc.TopNfiles = true
-		fs.WalkPrologue(ws)
+		wc.TopNatime = true
+		wc.DoTree = true
+		wc.CountLinks = topnLinks
+		wc.XDev = topnXDev
+		wc.Follow = topnFollow
+		wc.FromIndex = topnIndex
+		handleInterrupt(wc)
+		if wc.FromIndex != "" {
+			ws.RootPath = indexRootPath(args)
+			fs.IndexPrologue(wc)
+		} else {
+			ws.RootPath = fs.ParseRootPath(args)
+			fs.WalkPrologue(ws)
+		}
+		wc.Exclude = topnExclude.excluder(ws.RootPath)
 		start := time.Now()
-		fs.RunProfile(wc, ws)
+		runWalk(wc, ws)
 		fs.CalcRate(start, ws)
 		topnEpilogue(ws)
 	},
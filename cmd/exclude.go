@@ -0,0 +1,57 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+
+	"github.com/fwang2/pi/fs"
+	"github.com/spf13/cobra"
+)
+
+// defaultExcludes ... never walked
+var defaultExcludes = []string{
+	"/Volumes/GoogleDrive",
+	"/Volumes/Recovery",
+}
+
+// excludeOpts ... the exclusion flags of a command
+type excludeOpts struct {
+	globs   []string
+	regexes []string
+	files   []string
+}
+
+func (o *excludeOpts) addFlags(cmd *cobra.Command) {
+	cmd.Flags().StringArrayVar(&o.globs, "exclude", nil, "Leave out entries matching a name pattern, or a path pattern if it has a /")
+	cmd.Flags().StringArrayVar(&o.regexes, "exclude-regex", nil, "Leave out paths matching a regular expression")
+	cmd.Flags().StringArrayVar(&o.files, "exclude-from", nil, "Leave out what the gitignore-like rules in file match")
+}
+
+// excluder ... the exclusions of a walk from root: the defaults, the
+// paths in PI_EXCLUDE, then the flags
+func (o *excludeOpts) excluder(root string) *fs.Excluder {
+	ex := fs.NewExcluder(root)
+	for _, p := range defaultExcludes {
+		ex.AddPath(p)
+	}
+	if env := os.Getenv("PI_EXCLUDE"); env != "" {
+		for _, p := range strings.Split(env, ":") {
+			ex.AddPath(p)
+		}
+	}
+	for _, g := range o.globs {
+		ex.AddGlob(g)
+	}
+	for _, r := range o.regexes {
+		if err := ex.AddRegex(r); err != nil {
+			log.Fatalf("Can't parse --exclude-regex %s: %v", r, err)
+		}
+	}
+	for _, f := range o.files {
+		if err := ex.AddFile(f); err != nil {
+			log.Fatalf("Can't read --exclude-from: %v", err)
+		}
+	}
+	log.Debug("Exclusion:", ex.Paths, o.globs, o.regexes, o.files)
+	return ex
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"runtime"
+	"time"
 
 	"github.com/fwang2/pi/util"
 	"github.com/spf13/cobra"
@@ -11,6 +12,7 @@ import (
 
 var Verbose bool
 var NumOfWorkers int
+var Timeout time.Duration
 var log = util.NewLogger()
 
 var rootCmd = &cobra.Command{
@@ -23,6 +25,7 @@ func init() {
 	runtime.GOMAXPROCS(cpus)
 	rootCmd.PersistentFlags().BoolVarP(&Verbose, "verbose", "v", false, "verbose output")
 	rootCmd.PersistentFlags().IntVar(&NumOfWorkers, "np", cpus, "Number of worker threads")
+	rootCmd.PersistentFlags().DurationVar(&Timeout, "timeout", 0, "Stop after this long, as on Ctrl-C (e.g. 30m, 0 for no limit)")
 }
 
 // Execute ...
@@ -0,0 +1,50 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/fwang2/pi/fs"
+)
+
+// interruptContext ... a context canceled by the first SIGINT/SIGTERM,
+// or once --timeout has passed. The second signal exits right away.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	var timeout <-chan time.Time
+	if Timeout > 0 {
+		timeout = time.After(Timeout)
+	}
+
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case <-sigs:
+			fmt.Fprintf(os.Stderr, "\nInterrupted, finishing jobs in flight (again to quit now)\n")
+		case <-timeout:
+			fmt.Fprintf(os.Stderr, "\nTimed out after %v, finishing jobs in flight (Ctrl-C to quit now)\n", Timeout)
+		}
+		cancel()
+		<-sigs
+		os.Exit(130)
+	}()
+	return ctx
+}
+
+// handleInterrupt ... the walk stops on an interrupt or a timeout, jobs
+// in flight are drained and counted so a partial report can be printed.
+func handleInterrupt(wc *fs.WalkControl) {
+	wc.Stop = interruptContext().Done()
+}
+
+// printPartial ... a banner for reports of a stopped walk
+func printPartial(ws *fs.WalkStat) {
+	if ws.Partial {
+		fmt.Printf("\n*** Partial result: the scan was interrupted ***\n")
+	}
+}
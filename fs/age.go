@@ -0,0 +1,68 @@
+package fs
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/fwang2/pi/util"
+)
+
+// DefaultAgeBins ... 1d, 7d, 30d, 90d, 180d, 1y, 3y
+var DefaultAgeBins = []time.Duration{
+	util.Day,
+	7 * util.Day,
+	30 * util.Day,
+	90 * util.Day,
+	180 * util.Day,
+	util.Year,
+	3 * util.Year,
+}
+
+// ParseAgeField ... atime, ctime or mtime to its FB_* flag
+func ParseAgeField(s string) (Bits, error) {
+	flag, ok := timeMap[s]
+	if !ok || flag == FB_BTIME {
+		return 0, fmt.Errorf("unknown time field: %s. Must be one of {atime, mtime, ctime}", s)
+	}
+	return flag, nil
+}
+
+// fileTime ... one of atime, ctime or mtime (the default), picked by flag
+func fileTime(flag Bits, fi os.FileInfo) time.Time {
+	atime, ctime, mtime := util.StatsTime(fi.Sys().(*syscall.Stat_t))
+	switch {
+	case Has(flag, FB_ATIME):
+		return atime
+	case Has(flag, FB_CTIME):
+		return ctime
+	}
+	return mtime
+}
+
+// ageField ... the time fileTime picks by flag
+func ageField(flag Bits) Bits {
+	switch {
+	case Has(flag, FB_ATIME):
+		return FB_ATIME
+	case Has(flag, FB_CTIME):
+		return FB_CTIME
+	}
+	return FB_MTIME
+}
+
+// ageBucket ... index of the first bin age fits in, bins are inclusive
+// upper bounds and one more bucket holds what is older than the last
+func ageBucket(bins []time.Duration, age time.Duration) int {
+	return sort.Search(len(bins), func(i int) bool { return age <= bins[i] })
+}
+
+// AgeLabel ... label of the bucket i of bins
+func AgeLabel(bins []time.Duration, i int) string {
+	if i == len(bins) {
+		return "> " + util.ShortDuration(bins[len(bins)-1])
+	}
+	return "<= " + util.ShortDuration(bins[i])
+}
@@ -0,0 +1,49 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fwang2/pi/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgeHist(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+
+	// f1 is new, f2 10 days old, f3 and f4 two years old by mtime;
+	// all were accessed 100 days ago
+	now := time.Now()
+	atime := now.Add(-100 * util.Day)
+	for f, age := range map[string]time.Duration{
+		"f1":     0,
+		"a/f2":   10 * util.Day,
+		"a/f3":   2 * util.Year,
+		"b/c/f4": 2 * util.Year,
+	} {
+		assert.Nil(t, os.Chtimes(filepath.Join(root, f), atime, now.Add(-age)))
+	}
+
+	bins := []time.Duration{util.Day, 30 * util.Day, util.Year}
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2, AgeBins: bins}
+	RunProfile(&WalkControl{DoAgeHist: true, AgeField: FB_MTIME}, ws)
+	assert.Equal(t, []int64{1, 1, 0, 2}, ws.AgeFiles)
+	assert.Equal(t, ws.TotFileSize, ws.AgeBytes[0]+ws.AgeBytes[1]+ws.AgeBytes[3])
+	assert.Equal(t, int64(0), ws.AgeBytes[2])
+
+	ws = &WalkStat{RootPath: root, NumOfWorkers: 2, AgeBins: bins}
+	RunProfile(&WalkControl{DoAgeHist: true, AgeField: FB_ATIME}, ws)
+	assert.Equal(t, []int64{0, 0, 4, 0}, ws.AgeFiles)
+	assert.Equal(t, ws.TotFileSize, ws.AgeBytes[2])
+}
+
+func TestParseAgeField(t *testing.T) {
+	flag, err := ParseAgeField("ctime")
+	assert.Nil(t, err)
+	assert.Equal(t, FB_CTIME, flag)
+	_, err = ParseAgeField("btime")
+	assert.NotNil(t, err)
+}
@@ -13,6 +13,12 @@ const (
 	FB_MTIME
 	FB_ATIME
 	FB_CTIME
+	FB_USER   // owner, by --user or --uid
+	FB_GROUP  // group, by --group or --gid
+	FB_NOUSER // owner not in user database
+	FB_PERM
+	FB_BROKEN // symlink that can't be followed
+	FB_BTIME  // birth time, if the file system keeps it
 )
 
 const (
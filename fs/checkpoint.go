@@ -0,0 +1,235 @@
+package fs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// CheckpointVersion ... bump when the layout of Checkpoint changes
+const CheckpointVersion = 3
+
+// Checkpoint ... what is needed to continue an interrupted walk:
+// the directories not yet (fully) walked, and the counters of all
+// directories that were. A directory is either pending or counted,
+// never both, so resuming ends with the same totals.
+type Checkpoint struct {
+	Version int       `json:"version"`
+	Root    string    `json:"root"`
+	Saved   time.Time `json:"saved"`
+	Pending []string  `json:"pending"`
+
+	// options the counters were collected with
+	DoHist     bool `json:"hist"`
+	DoSparse   bool `json:"sparse"`
+	CountLinks bool `json:"count_links"`
+	XDev       bool `json:"xdev"`
+	Follow     bool `json:"follow"`
+	CheckLinks bool `json:"check_links"`
+	DoByUser   bool `json:"by_user"`
+	DoByGroup  bool `json:"by_group"`
+	DoByExt    bool `json:"by_ext"`
+	SniffExt   bool `json:"sniff_ext"`
+	DoAgeHist  bool `json:"age_hist"`
+	AgeField   Bits `json:"age_field,omitempty"`
+
+	// what was left out, and counted as excluded
+	Exclude *Excluder `json:"exclude,omitempty"`
+
+	Elapsed       time.Duration     `json:"elapsed"`
+	TotSkipped    int64             `json:"skipped"`
+	TotExcluded   int64             `json:"excluded"`
+	TotFileCnt    int64             `json:"files"`
+	TotFileSize   int64             `json:"file_size"`
+	TotDirCnt     int64             `json:"dirs"`
+	TotSymlinkCnt int64             `json:"symlinks"`
+	TotPipeCnt    int64             `json:"pipes"`
+	TotSparseCnt  int64             `json:"sparse_files"`
+	TotLinkCnt    int64             `json:"hardlinks"`
+	TotLinkSize   int64             `json:"hardlink_size"`
+	Links         []LinkKey         `json:"links,omitempty"`
+	Mounts        []string          `json:"mounts,omitempty"`
+	Visited       []LinkKey         `json:"visited,omitempty"`
+	BrokenLinks   []string          `json:"broken_links,omitempty"`
+	LoopLinks     []string          `json:"loop_links,omitempty"`
+	OutsideLinks  []string          `json:"outside_links,omitempty"`
+	HistBins      []int64           `json:"hist_bins,omitempty"`
+	HistCounter   []int64           `json:"hist_counter,omitempty"`
+	UserUsage     map[uint32]*Usage `json:"users,omitempty"`
+	GroupUsage    map[uint32]*Usage `json:"groups,omitempty"`
+	ExtUsage      map[string]*Usage `json:"exts,omitempty"`
+	AgeBins       []time.Duration   `json:"age_bins,omitempty"`
+	AgeFiles      []int64           `json:"age_files,omitempty"`
+	AgeBytes      []int64           `json:"age_bytes,omitempty"`
+	AgeNow        time.Time         `json:"age_now,omitempty"`
+}
+
+// NewCheckpoint ... snapshot the walk, pending is the set of directories
+// handed to the pool whose results are not merged yet.
+func NewCheckpoint(wc *WalkControl, ws *WalkStat, pending map[string]bool, elapsed time.Duration) *Checkpoint {
+	cp := &Checkpoint{
+		Version:       CheckpointVersion,
+		Root:          ws.RootPath,
+		Saved:         time.Now(),
+		Pending:       make([]string, 0, len(pending)),
+		DoHist:        wc.DoHist,
+		DoSparse:      wc.DoSparse,
+		CountLinks:    wc.CountLinks,
+		XDev:          wc.XDev,
+		Follow:        wc.Follow,
+		CheckLinks:    wc.CheckLinks,
+		DoByUser:      wc.DoByUser,
+		DoByGroup:     wc.DoByGroup,
+		DoByExt:       wc.DoByExt,
+		SniffExt:      wc.SniffExt,
+		DoAgeHist:     wc.DoAgeHist,
+		AgeField:      wc.AgeField,
+		Exclude:       wc.Exclude,
+		Elapsed:       ws.elapsedBefore + elapsed,
+		TotSkipped:    ws.TotSkipped,
+		TotExcluded:   ws.TotExcluded,
+		TotFileCnt:    ws.TotFileCnt,
+		TotFileSize:   ws.TotFileSize,
+		TotDirCnt:     ws.TotDirCnt,
+		TotSymlinkCnt: ws.TotSymlinkCnt,
+		TotPipeCnt:    ws.TotPipeCnt,
+		TotSparseCnt:  ws.TotSparseCnt,
+		TotLinkCnt:    ws.TotLinkCnt,
+		TotLinkSize:   ws.TotLinkSize,
+		Mounts:        ws.Mounts,
+		BrokenLinks:   ws.BrokenLinks,
+		LoopLinks:     ws.LoopLinks,
+		OutsideLinks:  ws.OutsideLinks,
+		UserUsage:     ws.UserUsage,
+		GroupUsage:    ws.GroupUsage,
+		ExtUsage:      ws.ExtUsage,
+	}
+	if wc.DoHist {
+		cp.HistBins = ws.HistBins
+		cp.HistCounter = ws.HistCounter
+	}
+	if wc.DoAgeHist {
+		cp.AgeBins = ws.AgeBins
+		cp.AgeFiles = ws.AgeFiles
+		cp.AgeBytes = ws.AgeBytes
+		cp.AgeNow = ws.AgeNow
+	}
+	if ws.Links != nil {
+		cp.Links = ws.Links.Committed()
+	}
+	if ws.visited != nil {
+		cp.Visited = ws.visited.Committed()
+	}
+	for d := range pending {
+		cp.Pending = append(cp.Pending, d)
+	}
+	sort.Strings(cp.Pending)
+	return cp
+}
+
+// Save ... write to file, through a temporary file and rename, so
+// a crash while saving leaves the previous checkpoint intact.
+func (cp *Checkpoint) Save(file string) error {
+	data, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(file), filepath.Base(file)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err = tmp.Write(data); err == nil {
+		err = tmp.Sync()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), file)
+}
+
+// LoadCheckpoint ... read a checkpoint written by Save
+func LoadCheckpoint(file string) (*Checkpoint, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	cp := new(Checkpoint)
+	if err := json.Unmarshal(data, cp); err != nil {
+		return nil, fmt.Errorf("%s: not a checkpoint: %v", file, err)
+	}
+	if cp.Version != CheckpointVersion {
+		return nil, fmt.Errorf("%s: checkpoint version %d, expect %d",
+			file, cp.Version, CheckpointVersion)
+	}
+	return cp, nil
+}
+
+// RestoreOptions ... the options of the checkpointed walk win over the
+// ones given now, so counters stay sound. A command sets them before
+// the walk to report on what the counters hold.
+func (cp *Checkpoint) RestoreOptions(wc *WalkControl) {
+	wc.DoHist = cp.DoHist
+	wc.DoSparse = cp.DoSparse
+	wc.CountLinks = cp.CountLinks
+	wc.XDev = cp.XDev
+	wc.Follow = cp.Follow
+	wc.CheckLinks = cp.CheckLinks
+	wc.DoByUser = cp.DoByUser
+	wc.DoByGroup = cp.DoByGroup
+	wc.DoByExt = cp.DoByExt
+	wc.SniffExt = cp.SniffExt
+	wc.DoAgeHist = cp.DoAgeHist
+	wc.AgeField = cp.AgeField
+	wc.Exclude = cp.Exclude
+}
+
+// Restore ... continue counting from the checkpoint, with its options
+func (cp *Checkpoint) Restore(wc *WalkControl, ws *WalkStat) {
+	cp.RestoreOptions(wc)
+
+	ws.RootPath = cp.Root
+	ws.elapsedBefore = cp.Elapsed
+	ws.TotSkipped = cp.TotSkipped
+	ws.TotExcluded = cp.TotExcluded
+	ws.TotFileCnt = cp.TotFileCnt
+	ws.TotFileSize = cp.TotFileSize
+	ws.TotDirCnt = cp.TotDirCnt
+	ws.TotSymlinkCnt = cp.TotSymlinkCnt
+	ws.TotPipeCnt = cp.TotPipeCnt
+	ws.TotSparseCnt = cp.TotSparseCnt
+	ws.TotLinkCnt = cp.TotLinkCnt
+	ws.TotLinkSize = cp.TotLinkSize
+	ws.Mounts = cp.Mounts
+	ws.BrokenLinks = cp.BrokenLinks
+	ws.LoopLinks = cp.LoopLinks
+	ws.OutsideLinks = cp.OutsideLinks
+	if cp.Follow {
+		ws.visited = NewLinkSet()
+		ws.visited.Commit(cp.Visited)
+	}
+	if !cp.CountLinks {
+		ws.Links = NewLinkSet()
+		ws.Links.Commit(cp.Links)
+	}
+	if cp.DoHist {
+		ws.HistBins = cp.HistBins
+		ws.HistCounter = cp.HistCounter
+	}
+	if cp.DoAgeHist {
+		ws.AgeBins = cp.AgeBins
+		ws.AgeFiles = cp.AgeFiles
+		ws.AgeBytes = cp.AgeBytes
+		ws.AgeNow = cp.AgeNow
+	}
+	ws.UserUsage = cp.UserUsage
+	ws.GroupUsage = cp.GroupUsage
+	ws.ExtUsage = cp.ExtUsage
+}
@@ -0,0 +1,147 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// createTree ... root/{f1, a/{f2, f3}, b/c/f4}
+func createTree(t *testing.T) string {
+	root, err := ioutil.TempDir("", "pi-tree")
+	assert.Nil(t, err)
+	for _, d := range []string{"a", "b/c"} {
+		assert.Nil(t, os.MkdirAll(filepath.Join(root, d), 0755))
+	}
+	for i, f := range []string{"f1", "a/f2", "a/f3", "b/c/f4"} {
+		data := make([]byte, 1000*(i+1))
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(root, f), data, 0644))
+	}
+	return root
+}
+
+func TestCheckpointResume(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+
+	full := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{DoByUser: true}, full)
+
+	// walk only the root, then checkpoint with its subdirectories pending
+	wc := &WalkControl{DoByUser: true}
+	partial := &WalkStat{RootPath: root, UserUsage: make(map[uint32]*Usage)}
+	res := Walk(wc, partial, root).(ScanResult)
+	partial.TotFileCnt = res.fileCnt
+	partial.TotDirCnt = res.dirCnt
+	partial.TotFileSize = res.fileSizeAgg
+	mergeUsage(partial.UserUsage, res.users)
+	pending := map[string]bool{}
+	for _, d := range res.dirs {
+		pending[d] = true
+	}
+
+	file := filepath.Join(root, "scan.ckpt")
+	assert.Nil(t, NewCheckpoint(wc, partial, pending, 0).Save(file))
+	cp, err := LoadCheckpoint(file)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(cp.Pending))
+
+	resumed := &WalkStat{NumOfWorkers: 2}
+	wc = &WalkControl{Checkpoint: file, Resume: cp}
+	RunProfile(wc, resumed)
+
+	assert.True(t, wc.DoByUser)
+	assert.Equal(t, root, resumed.RootPath)
+	assert.Equal(t, full.TotFileCnt, resumed.TotFileCnt)
+	assert.Equal(t, full.TotDirCnt, resumed.TotDirCnt)
+	assert.Equal(t, full.TotFileSize, resumed.TotFileSize)
+	assert.Equal(t, UserUsage(full), UserUsage(resumed))
+
+	// a completed walk removes its checkpoint
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStopSavesCheckpoint(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+
+	stop := make(chan struct{})
+	close(stop)
+	file := root + ".ckpt"
+	defer os.Remove(file)
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{Checkpoint: file, Stop: stop}, ws)
+	assert.True(t, ws.Partial)
+	assert.Equal(t, int64(0), ws.TotFileCnt)
+
+	cp, err := LoadCheckpoint(file)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{root}, cp.Pending)
+
+	resumed := &WalkStat{NumOfWorkers: 2}
+	RunProfile(&WalkControl{Resume: cp}, resumed)
+	assert.False(t, resumed.Partial)
+	assert.Equal(t, int64(4), resumed.TotFileCnt)
+
+	// a stop with nothing left to walk leaves the walk complete
+	cp.Pending = nil
+	done := &WalkStat{NumOfWorkers: 2}
+	RunProfile(&WalkControl{Resume: cp, Checkpoint: file, Stop: stop}, done)
+	assert.False(t, done.Partial)
+	_, err = os.Stat(file)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCheckpointExclude(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	exclude := func() *Excluder {
+		ex := NewExcluder(root)
+		ex.AddPath(filepath.Join(root, "b"))
+		ex.AddGlob("f3")
+		assert.Nil(t, ex.AddRegex(`/f9$`))
+		assert.Nil(t, ex.AddRules(strings.NewReader("a/\n!a/\n")))
+		return ex
+	}
+	full := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{Exclude: exclude()}, full)
+	assert.Equal(t, int64(2), full.TotFileCnt)
+
+	// stopped at once, then resumed without the exclusion flags
+	stop := make(chan struct{})
+	close(stop)
+	file := root + ".ckpt"
+	defer os.Remove(file)
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{Checkpoint: file, Stop: stop, Exclude: exclude()}, ws)
+	cp, err := LoadCheckpoint(file)
+	assert.Nil(t, err)
+	for _, p := range []string{"b", "a/f3", "a/f2", "a", "f1"} {
+		p = filepath.Join(root, p)
+		isDir := p == filepath.Join(root, "a")
+		assert.Equal(t, exclude().Excluded(p, isDir), cp.Exclude.Excluded(p, isDir), p)
+	}
+
+	resumed := &WalkStat{NumOfWorkers: 2}
+	RunProfile(&WalkControl{Resume: cp}, resumed)
+	assert.Equal(t, full.TotFileCnt, resumed.TotFileCnt)
+	assert.Equal(t, full.TotFileSize, resumed.TotFileSize)
+	assert.Equal(t, full.TotExcluded, resumed.TotExcluded)
+}
+
+func TestCheckpointOptions(t *testing.T) {
+	cp := NewCheckpoint(&WalkControl{DoHist: true, DoByGroup: true},
+		&WalkStat{RootPath: "/data"}, nil, 0)
+
+	// the options given now give way to those the counters have
+	wc := &WalkControl{DoByUser: true}
+	cp.RestoreOptions(wc)
+	assert.True(t, wc.DoHist)
+	assert.True(t, wc.DoByGroup)
+	assert.False(t, wc.DoByUser)
+}
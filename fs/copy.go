@@ -1,13 +1,13 @@
 package fs
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
-	"time"
 
 	"github.com/fwang2/pi/pool"
 	"github.com/fwang2/pi/util"
@@ -78,6 +78,11 @@ const (
 type CopyControl struct {
 	NumOfWorkers int
 	CopyMode     int
+	Exclude      *Excluder // entries not copied
+
+	// Ctx, if set, cancels the copy: the files queued are left out and
+	// those being copied stop at their next chunk.
+	Ctx context.Context
 }
 
 type CopyStat struct {
@@ -101,12 +106,13 @@ type CopyResult struct {
 
 // func handler(jo *CopyJob) CopyResult {
 // the following interface signature is fixed
-// as required by pool.Add() interface
+// as required by pool.AddCtx() interface
 // args[0] - the first argument
 // args[1] - the second, so on and so forth
 
-func handler(args ...interface{}) interface{} {
+func handler(ctx context.Context, args ...interface{}) interface{} {
 	jo := args[0].(CopyJob)
+	cc := args[1].(*CopyControl)
 	var res CopyResult
 
 	if jo.jtype == J_PREP {
@@ -119,6 +125,10 @@ func handler(args ...interface{}) interface{} {
 		for _, file := range files {
 			fullName := path.Join(jo.srcPath, file.Name())
 			mode := file.Mode()
+			if cc.Exclude.Excluded(fullName, mode.IsDir()) {
+				log.Debugf("Excluding %v\n", fullName)
+				continue
+			}
 
 			switch {
 			case mode.IsDir():
@@ -142,7 +152,7 @@ func handler(args ...interface{}) interface{} {
 		if !isExist {
 			os.MkdirAll(dstParentDir, 0744)
 		}
-		res.err = CopyFile(jo.srcPath, jo.dstPath)
+		res.err = CopyFileCtx(ctx, jo.srcPath, jo.dstPath)
 	}
 
 	if jo.jtype == J_SYMLINK {
@@ -176,7 +186,7 @@ func get_srcbase(srcs []string) (srcBase string) {
 
 func init_work_pool(cc *CopyControl, srcs []string, srcBase string, dstAbs string) (mypool *pool.Pool) {
 
-	mypool = pool.New(cc.NumOfWorkers)
+	mypool = pool.NewWithContext(cc.Ctx, cc.NumOfWorkers)
 	mypool.Run()
 
 	// initialize the pool job items with command line args
@@ -200,7 +210,7 @@ func init_work_pool(cc *CopyControl, srcs []string, srcBase string, dstAbs strin
 			fmt.Printf("Skip symoblic link %v\n", src)
 		}
 
-		mypool.Add(handler, jo)
+		mypool.AddCtx(cc.Ctx, handler, jo, cc)
 	}
 	return
 }
@@ -208,6 +218,12 @@ func init_work_pool(cc *CopyControl, srcs []string, srcBase string, dstAbs strin
 func RunCopy(cc *CopyControl, srcs []string, dest string) {
 	srcBase := get_srcbase(srcs)
 	dstAbs, _ := filepath.Abs(dest)
+	if cc.Exclude != nil && cc.Exclude.Root == "" {
+		cc.Exclude.Root = srcBase
+	}
+	if cc.Ctx == nil {
+		cc.Ctx = context.Background()
+	}
 	mypool := init_work_pool(cc, srcs, srcBase, dstAbs)
 
 	for {
@@ -215,12 +231,16 @@ func RunCopy(cc *CopyControl, srcs []string, dest string) {
 		if job == nil {
 			break
 		}
+		if job.Err != nil {
+			// dropped by a cancel
+			continue
+		}
 		result := job.Result.(CopyResult)
 		for _, dir := range result.dirs {
 			var jo CopyJob
 			jo.jtype = J_PREP
 			jo.srcPath = dir
-			mypool.Add(handler, jo)
+			mypool.AddCtx(cc.Ctx, handler, jo, cc)
 		}
 
 		for _, file := range result.files {
@@ -238,7 +258,7 @@ func RunCopy(cc *CopyControl, srcs []string, dest string) {
 			srcDir, fileName := filepath.Split(file)
 			relPath, _ := filepath.Rel(srcBase, srcDir)
 			jo.dstPath = filepath.Join(dstAbs, relPath, fileName)
-			mypool.Add(handler, jo)
+			mypool.AddCtx(cc.Ctx, handler, jo, cc)
 		}
 	} // end for
 	mypool.Stop()
@@ -266,80 +286,104 @@ The first case can be addressed by:
 
 */
 
-func copyn(ch chan<- error, srcfile string, dstfile string, start int64, nbytes int64) {
+// copyChunk ... bytes copied by copyn between looks at its context
+const copyChunk = 8 * util.MiB
+
+// copyn ... copy nbytes at offset start of srcfile to dstfile, a pool
+// job returning the error if any. It stops early when ctx is done.
+func copyn(ctx context.Context, args ...interface{}) interface{} {
+	srcfile, dstfile := args[0].(string), args[1].(string)
+	start, nbytes := args[2].(int64), args[3].(int64)
 
 	srcfh, err := os.Open(srcfile)
 	if err != nil {
 		log.Print("Can't open file for reading: ", srcfile)
-		ch <- err
-		return
+		return err
 	}
 	defer srcfh.Close()
 
 	dstfh, err := os.OpenFile(dstfile, os.O_WRONLY|os.O_CREATE, 0644)
 	if err != nil {
 		log.Print("Can't write to file: ", dstfile)
-		ch <- err
-		return
+		return err
 	}
 	defer dstfh.Close()
 
 	_, err = srcfh.Seek(start, os.SEEK_SET)
 	if err != nil {
-		ch <- err
-		return
+		return err
 	}
 	_, err = dstfh.Seek(start, os.SEEK_SET)
 	if err != nil {
-		ch <- err
-		return
+		return err
 	}
 
-	written, err := io.CopyN(dstfh, srcfh, nbytes)
-	if written != nbytes {
-		log.Print("Error of copy")
+	for nbytes > 0 {
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		n := nbytes
+		if n > copyChunk {
+			n = copyChunk
+		}
+		written, err := io.CopyN(dstfh, srcfh, n)
+		if err != nil {
+			log.Print("Error of copy")
+			return err
+		}
+		nbytes -= written
 	}
-	// write back error if any
-	ch <- err
+	return nil
 }
 
-func dispatch(srcfile string, dstfile string, fsize int64, nworkers int) (err error) {
+// dispatch ... copy srcfile to dstfile in nworkers chunks at once. The
+// first error cancels the chunks left, as ctx does.
+func dispatch(ctx context.Context, srcfile string, dstfile string, fsize int64, nworkers int) (err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	mypool := pool.NewWithContext(ctx, nworkers)
+	mypool.Run()
 
-	ch := make(chan error)
 	nbytes := fsize / int64(nworkers)
 	remainder := fsize % int64(nworkers)
 
 	for i := 0; i < nworkers; i++ {
 		offset := int64(i) * nbytes
-		go copyn(ch, srcfile, dstfile, offset, nbytes)
+		mypool.AddCtx(ctx, copyn, srcfile, dstfile, offset, nbytes)
 	}
 
 	if remainder != 0 {
-		// must update update offset first before update nworkers
 		offset := int64(nworkers) * nbytes
-		nworkers++
-		go copyn(ch, srcfile, dstfile, offset, remainder)
+		mypool.AddCtx(ctx, copyn, srcfile, dstfile, offset, remainder)
 	}
 
-	// TODO: need better estimate
-	timeout := time.After(60 * 60 * time.Second)
-	for i := 0; i < nworkers; i++ {
-		select {
-		case err = <-ch:
-			if err != nil {
-				// one of the copy task was bad, need to cancel all?
-				return
-			}
-		case <-timeout:
-			fmt.Println("copy error")
-			return
+	for {
+		job := mypool.WaitForJob()
+		if job == nil {
+			break
+		}
+		jerr := job.Err
+		if jerr == nil && job.Result != nil {
+			jerr = job.Result.(error)
+		}
+		if jerr != nil && err == nil {
+			// the first error, the other chunks are of no use
+			err = jerr
+			cancel()
 		}
 	}
+	mypool.Stop()
 	return
 }
 
 // CopyFile ... copy file from srcfile to destination
 func CopyFile(srcfile string, dstfile string) (err error) {
+	return CopyFileCtx(context.Background(), srcfile, dstfile)
+}
+
+// CopyFileCtx ... CopyFile, stopping early with ctx.Err() once ctx is
+// done. The destination is left incomplete then.
+func CopyFileCtx(ctx context.Context, srcfile string, dstfile string) (err error) {
 
 	srcfh, err := os.Open(srcfile)
 	if err != nil {
@@ -376,7 +420,7 @@ func CopyFile(srcfile string, dstfile string) (err error) {
 		nworkers = 256
 	}
 
-	err = dispatch(srcfile, dstfile, fsize, nworkers)
+	err = dispatch(ctx, srcfile, dstfile, fsize, nworkers)
 
 	return
 }
@@ -1,7 +1,10 @@
 package fs
 
 import (
+	"context"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"strconv"
 	"testing"
 
@@ -35,3 +38,28 @@ func TestCopy(t *testing.T) {
 	}
 
 }
+
+func TestCopyCanceled(t *testing.T) {
+	srcFile := CreateNonSparseFile(1 * util.MiB)
+	defer os.Remove(srcFile)
+	dstFile := "dst.canceled"
+	defer os.Remove(dstFile)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err := CopyFileCtx(ctx, srcFile, dstFile)
+	assert.Equal(t, context.Canceled, err)
+
+	// nothing queued is copied
+	src, err := ioutil.TempDir("", "pi-cp-src")
+	assert.Nil(t, err)
+	defer os.RemoveAll(src)
+	dst, err := ioutil.TempDir("", "pi-cp-dst")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dst)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(src, "a"), []byte("a"), 0644))
+
+	RunCopy(&CopyControl{NumOfWorkers: 2, Ctx: ctx}, []string{src}, dst)
+	_, err = os.Stat(filepath.Join(dst, "a"))
+	assert.True(t, os.IsNotExist(err))
+}
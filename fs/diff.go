@@ -0,0 +1,332 @@
+package fs
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// kinds of FileChange
+const (
+	DIFF_ADDED    = "added"
+	DIFF_REMOVED  = "removed"
+	DIFF_GROWN    = "grown"
+	DIFF_SHRUNK   = "shrunk"
+	DIFF_MODIFIED = "modified"
+)
+
+// DiffControl ... options of DiffIndex
+type DiffControl struct {
+	TopN  int  // number of directories ranked by growth
+	Depth int  // only rank directories this deep below the root, 0 for any
+	List  bool // keep every changed file in DiffStat.Changes
+}
+
+// DiffCount ... number of files and the bytes they account for
+type DiffCount struct {
+	Files int64 `json:"files"`
+	Bytes int64 `json:"bytes"`
+}
+
+// DirDelta ... change of a directory, its subdirectories included
+type DirDelta struct {
+	Path   string `json:"path"`
+	Bytes  int64  `json:"bytes"`
+	Inodes int64  `json:"inodes"`
+}
+
+// FileChange ... one changed file, Kind is one of DIFF_*
+type FileChange struct {
+	Kind    string `json:"kind"`
+	Path    string `json:"path"`
+	OldSize int64  `json:"old_size"`
+	NewSize int64  `json:"new_size"`
+}
+
+// DiffStat ... what changed between two snapshots. Bytes of grown and
+// shrunk files are the size difference, Bytes and Inodes the net change
+// of the whole tree.
+type DiffStat struct {
+	Root     string       `json:"root"`
+	Old      time.Time    `json:"old"`
+	New      time.Time    `json:"new"`
+	Added    DiffCount    `json:"added"`
+	Removed  DiffCount    `json:"removed"`
+	Grown    DiffCount    `json:"grown"`
+	Shrunk   DiffCount    `json:"shrunk"`
+	Modified DiffCount    `json:"modified"`
+	Bytes    int64        `json:"bytes"`
+	Inodes   int64        `json:"inodes"`
+	TopDirs  []DirDelta   `json:"top_dirs"`
+	Changes  []FileChange `json:"changes,omitempty"`
+
+	// per directory change, rolled up by rollupDirs
+	dirs map[string]*DirDelta
+}
+
+// snapEntry ... what is kept of an entry of the old snapshot
+type snapEntry struct {
+	mode  os.FileMode
+	bytes int64
+	mtime int64
+}
+
+func newSnapEntry(dir string, fi os.FileInfo) snapEntry {
+	e := snapEntry{mode: fi.Mode(), mtime: fi.ModTime().UnixNano()}
+	if fi.Mode().IsRegular() {
+		e.bytes = FileSize(dir, fi)
+	}
+	return e
+}
+
+// pendingEntries ... entries of one snapshot still waiting for their
+// counterpart in the other, by directory then name
+type pendingEntries map[string]map[string]snapEntry
+
+func (p pendingEntries) put(dir string, name string, e snapEntry) {
+	m := p[dir]
+	if m == nil {
+		m = make(map[string]snapEntry)
+		p[dir] = m
+	}
+	m[name] = e
+}
+
+// take ... remove and return the entry dir/name if it is waiting
+func (p pendingEntries) take(dir string, name string) (snapEntry, bool) {
+	m := p[dir]
+	e, ok := m[name]
+	if ok {
+		delete(m, name)
+		if len(m) == 0 {
+			delete(p, dir)
+		}
+	}
+	return e, ok
+}
+
+// DiffIndex ... compare two indexes of the same root. Neither is held
+// in memory: both are read a record at a time, side by side, and an
+// entry is dropped as soon as its counterpart shows up in the other.
+// What is held is what one scan reached and the other not yet, plus
+// the added and removed entries, so memory grows with how differently
+// the two scans were ordered and with how much changed, not with the
+// size of the tree.
+func DiffIndex(dc *DiffControl, oldFile string, newFile string) (*DiffStat, error) {
+	old, err := OpenIndex(oldFile)
+	if err != nil {
+		return nil, err
+	}
+	defer old.Close()
+	cur, err := OpenIndex(newFile)
+	if err != nil {
+		return nil, err
+	}
+	defer cur.Close()
+
+	if filepath.Clean(old.Root) != filepath.Clean(cur.Root) {
+		return nil, fmt.Errorf("snapshots are of different roots: %s and %s", old.Root, cur.Root)
+	}
+	// what a stopped scan didn't get to would show as removed or added
+	if old.Partial {
+		return nil, fmt.Errorf("%s is a partial scan, stopped before its end", oldFile)
+	}
+	if cur.Partial {
+		return nil, fmt.Errorf("%s is a partial scan, stopped before its end", newFile)
+	}
+	ds := &DiffStat{Root: cur.Root, Old: old.Created, New: cur.Created,
+		dirs: make(map[string]*DirDelta)}
+
+	before, after := make(pendingEntries), make(pendingEntries)
+	oldDone, curDone := false, false
+	for !oldDone || !curDone {
+		if !oldDone {
+			dir, entries, err := old.Next()
+			if err == io.EOF {
+				oldDone = true
+			} else if err != nil {
+				return nil, err
+			}
+			for _, fi := range entries {
+				was := newSnapEntry(dir, fi)
+				if now, ok := after.take(dir, fi.Name()); ok {
+					ds.compare(dc, dir, filepath.Join(dir, fi.Name()), was, now)
+				} else {
+					before.put(dir, fi.Name(), was)
+				}
+			}
+		}
+		if !curDone {
+			dir, entries, err := cur.Next()
+			if err == io.EOF {
+				curDone = true
+			} else if err != nil {
+				return nil, err
+			}
+			for _, fi := range entries {
+				now := newSnapEntry(dir, fi)
+				if was, ok := before.take(dir, fi.Name()); ok {
+					ds.compare(dc, dir, filepath.Join(dir, fi.Name()), was, now)
+				} else {
+					after.put(dir, fi.Name(), now)
+				}
+			}
+		}
+	}
+	for dir, m := range before {
+		for name, was := range m {
+			ds.remove(dc, dir, filepath.Join(dir, name), was)
+		}
+	}
+	for dir, m := range after {
+		for name, now := range m {
+			ds.add(dc, dir, filepath.Join(dir, name), now)
+		}
+	}
+
+	ds.rollupDirs()
+	ds.rankDirs(dc)
+	sort.Slice(ds.Changes, func(i, j int) bool { return ds.Changes[i].Path < ds.Changes[j].Path })
+	return ds, nil
+}
+
+func (ds *DiffStat) dir(path string) *DirDelta {
+	d := ds.dirs[path]
+	if d == nil {
+		d = &DirDelta{Path: path}
+		ds.dirs[path] = d
+	}
+	return d
+}
+
+func (ds *DiffStat) record(dc *DiffControl, kind string, fname string, was, now snapEntry) {
+	if dc.List {
+		ds.Changes = append(ds.Changes,
+			FileChange{Kind: kind, Path: fname, OldSize: was.bytes, NewSize: now.bytes})
+	}
+}
+
+func (ds *DiffStat) add(dc *DiffControl, dir string, fname string, now snapEntry) {
+	d := ds.dir(dir)
+	d.Bytes += now.bytes
+	d.Inodes++
+	if !now.mode.IsDir() {
+		ds.Added.Files++
+		ds.Added.Bytes += now.bytes
+		ds.record(dc, DIFF_ADDED, fname, snapEntry{}, now)
+	}
+}
+
+func (ds *DiffStat) remove(dc *DiffControl, dir string, fname string, was snapEntry) {
+	d := ds.dir(dir)
+	d.Bytes -= was.bytes
+	d.Inodes--
+	if !was.mode.IsDir() {
+		ds.Removed.Files++
+		ds.Removed.Bytes += was.bytes
+		ds.record(dc, DIFF_REMOVED, fname, was, snapEntry{})
+	}
+}
+
+// compare ... an entry found in both snapshots; one that turned from a
+// directory into a file or back is removed and added
+func (ds *DiffStat) compare(dc *DiffControl, dir string, fname string, was, now snapEntry) {
+	if was.mode.IsDir() == now.mode.IsDir() {
+		ds.change(dc, dir, fname, was, now)
+		return
+	}
+	ds.remove(dc, dir, fname, was)
+	ds.add(dc, dir, fname, now)
+}
+
+func (ds *DiffStat) change(dc *DiffControl, dir string, fname string, was, now snapEntry) {
+	if now.mode.IsDir() {
+		return
+	}
+	delta := now.bytes - was.bytes
+	switch {
+	case delta > 0:
+		ds.Grown.Files++
+		ds.Grown.Bytes += delta
+		ds.record(dc, DIFF_GROWN, fname, was, now)
+	case delta < 0:
+		ds.Shrunk.Files++
+		ds.Shrunk.Bytes -= delta
+		ds.record(dc, DIFF_SHRUNK, fname, was, now)
+	case now.mtime != was.mtime:
+		ds.Modified.Files++
+		ds.record(dc, DIFF_MODIFIED, fname, was, now)
+	default:
+		return
+	}
+	ds.dir(dir).Bytes += delta
+}
+
+// rollupDirs ... add the change of each directory to all its ancestors
+func (ds *DiffStat) rollupDirs() {
+	direct := make([]DirDelta, 0, len(ds.dirs))
+	for _, d := range ds.dirs {
+		direct = append(direct, *d)
+	}
+
+	root := ds.dir(ds.Root)
+	for _, d := range direct {
+		if !isUnder(ds.Root, d.Path) {
+			continue
+		}
+		for p := filepath.Dir(d.Path); ; p = filepath.Dir(p) {
+			a := ds.dir(p)
+			a.Bytes += d.Bytes
+			a.Inodes += d.Inodes
+			if a == root {
+				break
+			}
+		}
+	}
+	ds.Bytes, ds.Inodes = root.Bytes, root.Inodes
+}
+
+// rankDirs ... the top dc.TopN directories by byte growth
+func (ds *DiffStat) rankDirs(dc *DiffControl) {
+	ds.TopDirs = make([]DirDelta, 0, dc.TopN)
+	rootDepth := depth(strings.TrimSuffix(ds.Root, "/"))
+	for _, d := range ds.dirs {
+		if d.Path == ds.Root || d.Bytes <= 0 {
+			continue
+		}
+		if dc.Depth > 0 && depth(d.Path)-rootDepth > dc.Depth {
+			continue
+		}
+		ds.TopDirs = append(ds.TopDirs, *d)
+	}
+	sort.Slice(ds.TopDirs, func(i, j int) bool {
+		if ds.TopDirs[i].Bytes != ds.TopDirs[j].Bytes {
+			return ds.TopDirs[i].Bytes > ds.TopDirs[j].Bytes
+		}
+		return ds.TopDirs[i].Path < ds.TopDirs[j].Path
+	})
+	if len(ds.TopDirs) > dc.TopN {
+		ds.TopDirs = ds.TopDirs[:dc.TopN]
+	}
+}
+
+// Dir ... the rolled up change of a directory
+func (ds *DiffStat) Dir(path string) DirDelta {
+	if d, ok := ds.dirs[filepath.Clean(path)]; ok {
+		return *d
+	}
+	return DirDelta{Path: path}
+}
+
+func depth(path string) int {
+	return strings.Count(filepath.Clean(path), string(filepath.Separator))
+}
+
+// isUnder ... true if path is strictly below root
+func isUnder(root string, path string) bool {
+	return path != root && strings.HasPrefix(path, strings.TrimSuffix(root, "/")+"/")
+}
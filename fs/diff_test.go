@@ -0,0 +1,120 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffIndex(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	oldFile, newFile := root+".old.pidx", root+".new.pidx"
+	defer os.Remove(oldFile)
+	defer os.Remove(newFile)
+	buildIndex(t, root, oldFile)
+
+	// root/{f1, a/{f2, f3}, b/c/f4} becomes
+	// root/{f1*, a/{f2+}, b/c/{f4-, f5, d/f6}}
+	write := func(f string, size int) {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(root, f), make([]byte, size), 0644))
+	}
+	write("a/f2", 10000)
+	assert.Nil(t, os.Remove(filepath.Join(root, "a/f3")))
+	write("b/c/f4", 1000)
+	write("b/c/f5", 5000)
+	assert.Nil(t, os.Mkdir(filepath.Join(root, "b/c/d"), 0755))
+	write("b/c/d/f6", 6000)
+	later := time.Now().Add(time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(root, "f1"), later, later))
+	buildIndex(t, root, newFile)
+
+	ds, err := DiffIndex(&DiffControl{TopN: 3, List: true}, oldFile, newFile)
+	assert.Nil(t, err)
+
+	// sizes are in allocated blocks, capped by the file size
+	size := func(f string) int64 {
+		fi, err := os.Lstat(filepath.Join(root, f))
+		assert.Nil(t, err)
+		return FileSize(root, fi)
+	}
+	assert.Equal(t, DiffCount{2, size("b/c/f5") + size("b/c/d/f6")}, ds.Added)
+	assert.Equal(t, int64(1), ds.Removed.Files)
+	assert.Equal(t, int64(1), ds.Grown.Files)
+	assert.Equal(t, int64(1), ds.Shrunk.Files)
+	assert.Equal(t, int64(1), ds.Modified.Files)
+	assert.Equal(t, int64(2), ds.Inodes)
+	assert.Equal(t, ds.Added.Bytes-ds.Removed.Bytes+ds.Grown.Bytes-ds.Shrunk.Bytes, ds.Bytes)
+
+	var kinds []string
+	for _, c := range ds.Changes {
+		rel, _ := filepath.Rel(root, c.Path)
+		kinds = append(kinds, c.Kind+" "+rel)
+	}
+	assert.Equal(t, []string{
+		"grown a/f2",
+		"removed a/f3",
+		"added b/c/d/f6",
+		"shrunk b/c/f4",
+		"added b/c/f5",
+		"modified f1",
+	}, kinds)
+
+	// b/c gained f5, d and f6, and lost part of f4
+	c := ds.Dir(filepath.Join(root, "b/c"))
+	assert.Equal(t, int64(3), c.Inodes)
+	b := ds.Dir(filepath.Join(root, "b"))
+	assert.Equal(t, c.Bytes, b.Bytes)
+	assert.Equal(t, c.Inodes, b.Inodes)
+	assert.Equal(t, int64(-1), ds.Dir(filepath.Join(root, "a")).Inodes)
+	if assert.True(t, len(ds.TopDirs) > 0) {
+		assert.True(t, ds.TopDirs[0].Bytes >= ds.TopDirs[len(ds.TopDirs)-1].Bytes)
+	}
+	for _, d := range ds.TopDirs {
+		assert.True(t, d.Bytes > 0)
+	}
+}
+
+func TestDiffIndexRoots(t *testing.T) {
+	a, b := createTree(t), createTree(t)
+	defer os.RemoveAll(a)
+	defer os.RemoveAll(b)
+	defer os.Remove(a + ".pidx")
+	defer os.Remove(b + ".pidx")
+	buildIndex(t, a, a+".pidx")
+	buildIndex(t, b, b+".pidx")
+
+	_, err := DiffIndex(&DiffControl{}, a+".pidx", b+".pidx")
+	assert.NotNil(t, err)
+}
+
+func TestDiffIndexKind(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	oldFile, sameFile, newFile := root+".old.pidx", root+".same.pidx", root+".new.pidx"
+	defer os.Remove(oldFile)
+	defer os.Remove(sameFile)
+	defer os.Remove(newFile)
+	buildIndex(t, root, oldFile)
+	buildIndex(t, root, sameFile)
+
+	ds, err := DiffIndex(&DiffControl{List: true}, oldFile, sameFile)
+	assert.Nil(t, err)
+	assert.Empty(t, ds.Changes)
+	assert.Equal(t, int64(0), ds.Inodes)
+
+	// directory a becomes a file
+	assert.Nil(t, os.RemoveAll(filepath.Join(root, "a")))
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(root, "a"), []byte("a"), 0644))
+	buildIndex(t, root, newFile)
+
+	ds, err = DiffIndex(&DiffControl{List: true}, oldFile, newFile)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), ds.Added.Files)
+	assert.Equal(t, int64(2), ds.Removed.Files)
+	assert.Equal(t, int64(-2), ds.Inodes)
+}
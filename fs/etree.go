@@ -1,6 +1,12 @@
 package fs
 
-import "os"
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/fwang2/pi/util"
+)
 
 type And struct {
 	Left, Right Node
@@ -14,18 +20,103 @@ type Not struct {
 	Elem Node
 }
 
+// Name ... true if the entry name matches a glob pattern
+type Name struct {
+	Pattern string
+}
+
+// Size ... true if the entry size compares to Size with Op
+type Size struct {
+	Op   string
+	Size int64
+}
+
+// Type ... true if the entry type is one of FB_TYPE_* in Flags
+type Type struct {
+	Flags Bits
+}
+
+// Time ... true if one of a/c/m time (picked by Flag) falls in Range
+type Time struct {
+	Flag  Bits
+	Range TimeRange
+}
+
+// Owner ... true if the uid (Flag is FB_USER) or gid (FB_GROUP) is ID
+type Owner struct {
+	Flag Bits
+	ID   uint32
+}
+
+// NoUser ... true if the uid doesn't resolve to a user
+type NoUser struct{}
+
+// BrokenLink ... true if a symlink whose target doesn't exist, or loops
+type BrokenLink struct{}
+
+// Perm ... permission bits test, Op is one of
+// PERM_EXACT, PERM_ALL or PERM_ANY as in GNU find
+type Perm struct {
+	Op   string
+	Mode uint32
+}
+
+// Node ... an expression, evaluated on the entry fi of dir
 type Node interface {
-	Eval(findc *FindControl, fi os.FileInfo) bool
+	Eval(findc *FindControl, dir string, fi os.FileInfo) bool
+}
+
+func (a And) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	return a.Left.Eval(findc, dir, fi) && a.Right.Eval(findc, dir, fi)
+}
+
+func (o Or) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	return o.Left.Eval(findc, dir, fi) || o.Right.Eval(findc, dir, fi)
+}
+
+func (n Not) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	return !n.Elem.Eval(findc, dir, fi)
+}
+
+func (n Name) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	return match_name(n.Pattern, fi.Name())
+}
+
+func (s Size) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	return compare_size(s.Op, s.Size, fi.Size())
+}
+
+func (t Type) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	return match_ftype(t.Flags, fileType(fi))
+}
+
+func (t Time) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	return match_time(t.Flag, t.Range, fi)
+}
+
+func (o Owner) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	stat := fi.Sys().(*syscall.Stat_t)
+	if Has(o.Flag, FB_GROUP) {
+		return stat.Gid == o.ID
+	}
+	return stat.Uid == o.ID
 }
 
-func (a And) Eval(findc *FindControl, fi os.FileInfo) bool {
-	return a.Left.Eval(findc, fi) && a.Right.Eval(findc, fi)
+func (n NoUser) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	stat := fi.Sys().(*syscall.Stat_t)
+	_, ok := util.UserName(stat.Uid)
+	return !ok
 }
 
-func (o Or) Eval(findc *FindControl, fi os.FileInfo) bool {
-	return o.Left.Eval(findc, fi) || o.Right.Eval(findc, fi)
+func (p Perm) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	stat := fi.Sys().(*syscall.Stat_t)
+	return match_perm(p.Op, p.Mode, uint32(stat.Mode)&07777)
 }
 
-func (n Not) Eval(findc *FindControl, fi os.FileInfo) bool {
-	return !n.Elem.Eval(findc, fi)
+func (b BrokenLink) Eval(findc *FindControl, dir string, fi os.FileInfo) bool {
+	if fileType(fi)&os.ModeSymlink == 0 {
+		return false
+	}
+	_, err := os.Stat(filepath.Join(dir, fi.Name()))
+	return err != nil && linkError(err) != LINK_OK
 }
@@ -0,0 +1,259 @@
+package fs
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fwang2/fnmatch"
+)
+
+// what an excludeRule is matched against
+const (
+	onName = iota
+	onPath
+	onRel // the path relative to the root
+)
+
+// excludeRule ... one rule of an Excluder, a fnmatch pattern if regex
+// is not set
+type excludeRule struct {
+	glob    string
+	regex   *regexp.Regexp
+	on      int
+	negate  bool // re-include what earlier rules excluded
+	dirOnly bool
+}
+
+// Excluder ... decides which entries a walk leaves out, an excluded
+// directory is not descended. Rules are tried in order and the last
+// one matching wins, so that a negated rule can re-include.
+// It is read only once built, and safe for concurrent use.
+type Excluder struct {
+	Root  string          // anchored rules are relative to it
+	Paths map[string]bool // exact paths
+	rules []excludeRule
+}
+
+// NewExcluder ... no exclusion yet, for a walk from root
+func NewExcluder(root string) *Excluder {
+	return &Excluder{Root: root, Paths: make(map[string]bool)}
+}
+
+// AddPath ... leave out exactly path
+func (e *Excluder) AddPath(path string) {
+	e.Paths[filepath.Clean(path)] = true
+}
+
+// AddGlob ... leave out what matches a fnmatch pattern, as find --name.
+// A pattern with a "/" is matched against the whole path instead.
+func (e *Excluder) AddGlob(pattern string) {
+	rule := excludeRule{glob: pattern, on: onName}
+	if strings.Contains(pattern, "/") {
+		rule.on = onPath
+	}
+	e.rules = append(e.rules, rule)
+}
+
+// AddRegex ... leave out the paths a regular expression matches
+func (e *Excluder) AddRegex(expr string) error {
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return err
+	}
+	e.rules = append(e.rules, excludeRule{regex: re, on: onPath})
+	return nil
+}
+
+// AddRules ... read rules in the gitignore format: one pattern per line,
+// blank lines and lines starting with "#" are skipped, "!" negates, a
+// trailing "/" matches directories only, "**" matches any number of
+// directories. A pattern with a "/" other than a trailing one is
+// relative to the root, otherwise it matches the name at any depth.
+func (e *Excluder) AddRules(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimRight(scanner.Text(), " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule := excludeRule{on: onName}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if strings.Contains(line, "/") {
+			rule.on = onRel
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			return fmt.Errorf("line %d: empty pattern", n)
+		}
+		re, err := regexp.Compile("^" + globRegexp(line) + "$")
+		if err != nil {
+			return fmt.Errorf("line %d: %v", n, err)
+		}
+		rule.regex = re
+		e.rules = append(e.rules, rule)
+	}
+	return scanner.Err()
+}
+
+// AddFile ... AddRules from a file
+func (e *Excluder) AddFile(file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := e.AddRules(f); err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+	return nil
+}
+
+// Excluded ... true if the entry at path is left out
+func (e *Excluder) Excluded(path string, isDir bool) bool {
+	if e == nil {
+		return false
+	}
+	if e.Paths[path] {
+		return true
+	}
+	name := filepath.Base(path)
+	excluded := false
+	for _, r := range e.rules {
+		if r.dirOnly && !isDir {
+			continue
+		}
+		if r.match(e.Root, path, name) {
+			excluded = !r.negate
+		}
+	}
+	return excluded
+}
+
+// savedExcluder ... an Excluder as saved in a checkpoint
+type savedExcluder struct {
+	Root  string      `json:"root"`
+	Paths []string    `json:"paths,omitempty"`
+	Rules []savedRule `json:"rules,omitempty"`
+}
+
+type savedRule struct {
+	Glob    string `json:"glob,omitempty"`
+	Regex   string `json:"regex,omitempty"`
+	On      int    `json:"on"`
+	Negate  bool   `json:"negate,omitempty"`
+	DirOnly bool   `json:"dir_only,omitempty"`
+}
+
+// MarshalJSON ... the rules in order, the regular expressions as source
+func (e *Excluder) MarshalJSON() ([]byte, error) {
+	s := savedExcluder{Root: e.Root}
+	for p := range e.Paths {
+		s.Paths = append(s.Paths, p)
+	}
+	sort.Strings(s.Paths)
+	for _, r := range e.rules {
+		sr := savedRule{Glob: r.glob, On: r.on, Negate: r.negate, DirOnly: r.dirOnly}
+		if r.regex != nil {
+			sr.Regex = r.regex.String()
+		}
+		s.Rules = append(s.Rules, sr)
+	}
+	return json.Marshal(s)
+}
+
+// UnmarshalJSON ... an Excluder as written by MarshalJSON
+func (e *Excluder) UnmarshalJSON(data []byte) error {
+	var s savedExcluder
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	*e = *NewExcluder(s.Root)
+	for _, p := range s.Paths {
+		e.AddPath(p)
+	}
+	for _, sr := range s.Rules {
+		r := excludeRule{glob: sr.Glob, on: sr.On, negate: sr.Negate, dirOnly: sr.DirOnly}
+		if sr.Regex != "" {
+			re, err := regexp.Compile(sr.Regex)
+			if err != nil {
+				return err
+			}
+			r.regex = re
+		}
+		e.rules = append(e.rules, r)
+	}
+	return nil
+}
+
+func (r *excludeRule) match(root string, path string, name string) bool {
+	s := name
+	switch r.on {
+	case onPath:
+		s = path
+	case onRel:
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, "../") {
+			return false
+		}
+		s = rel
+	}
+	if r.regex != nil {
+		return r.regex.MatchString(s)
+	}
+	return fnmatch.Match(r.glob, s, 0)
+}
+
+// globRegexp ... translate a gitignore pattern to a regular expression,
+// "*" and "?" don't match a "/"
+func globRegexp(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		atDir := i == 0 || p[i-1] == '/'
+		switch c := p[i]; {
+		case atDir && strings.HasPrefix(p[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case atDir && p[i:] == "**":
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(p[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				break
+			}
+			class := p[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + class + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(p):
+			b.WriteString(regexp.QuoteMeta(p[i+1 : i+2]))
+			i++
+		default:
+			b.WriteString(regexp.QuoteMeta(p[i : i+1]))
+		}
+	}
+	return b.String()
+}
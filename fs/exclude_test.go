@@ -0,0 +1,87 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExcluderGlobRegex(t *testing.T) {
+	ex := NewExcluder("/data")
+	ex.AddPath("/data/skip/")
+	ex.AddGlob("*.tmp")
+	ex.AddGlob("/data/*/cache")
+	assert.Nil(t, ex.AddRegex(`/\.snapshot(/|$)`))
+	assert.NotNil(t, ex.AddRegex(`(`))
+
+	assert.True(t, ex.Excluded("/data/skip", true))
+	assert.False(t, ex.Excluded("/data/skip2", true))
+	assert.True(t, ex.Excluded("/data/a/b/x.tmp", false))
+	assert.False(t, ex.Excluded("/data/a/b/x.tmpl", false))
+	assert.True(t, ex.Excluded("/data/u1/cache", true))
+	assert.True(t, ex.Excluded("/data/.snapshot", true))
+	assert.False(t, ex.Excluded("/data/.snapshots", true))
+
+	var none *Excluder
+	assert.False(t, none.Excluded("/data/x.tmp", false))
+}
+
+func TestExcluderRules(t *testing.T) {
+	rules := `
+# build output
+*.o
+!keep.o
+build/
+/top.log
+docs/**/*.pdf
+**/cache
+\#hash
+`
+	ex := NewExcluder("/r")
+	assert.Nil(t, ex.AddRules(strings.NewReader(rules)))
+
+	for path, want := range map[string]bool{
+		"/r/a.o":              true,
+		"/r/x/y/b.o":          true,
+		"/r/x/keep.o":         false,
+		"/r/top.log":          true,
+		"/r/sub/top.log":      false,
+		"/r/docs/a/b/c.pdf":   true,
+		"/r/docs/c.pdf":       true,
+		"/r/other/docs/c.pdf": false,
+		"/r/a/b/cache":        true,
+		"/r/cache":            true,
+		"/r/#hash":            true,
+		"/r/src/main.c":       false,
+	} {
+		assert.Equal(t, want, ex.Excluded(path, false), path)
+	}
+	// directories only
+	assert.True(t, ex.Excluded("/r/x/build", true))
+	assert.False(t, ex.Excluded("/r/x/build", false))
+
+	assert.NotNil(t, NewExcluder("/r").AddRules(strings.NewReader("/\n")))
+}
+
+func TestWalkExclude(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	// root/{f1, a/{f2, f3}, b/c/f4}
+	rules := filepath.Join(root, "rules")
+	assert.Nil(t, ioutil.WriteFile(rules, []byte("b/\nrules\n"), 0644))
+
+	ex := NewExcluder("")
+	ex.AddGlob("f3")
+	assert.Nil(t, ex.AddFile(rules))
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{Exclude: ex, DoTree: true}, ws)
+	assert.Equal(t, root, ex.Root)
+	assert.Equal(t, int64(2), ws.TotFileCnt)
+	assert.Equal(t, int64(1), ws.TotDirCnt)
+	assert.Equal(t, int64(3), ws.TotExcluded)
+	assert.Nil(t, ws.Tree.Dir(filepath.Join(root, "b")))
+}
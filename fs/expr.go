@@ -0,0 +1,463 @@
+package fs
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fwang2/pi/util"
+)
+
+// A find expression follows GNU find:
+//
+//	expr    := and { (-o | --or) and }
+//	and     := unary { [-a | --and] unary }
+//	unary   := (! | --not) unary | "(" expr ")" | primary
+//	primary := --name PAT | --size N | --type T
+//	         | --atime D | --mtime D | --ctime D | --btime D
+//	         | --user U | --uid N | --group G | --gid N | --nouser
+//	         | --perm MODE | --broken-links
+//
+// D is a duration (7d, -7d) or a range of ages (30d..90d). MODE is
+// octal or symbolic (u+w,o=r), prefixed by "-" to require all of the
+// bits, or by "/" to require any of them.
+//
+// Two primaries next to each other are AND'ed. Primaries other than
+// --nouser and --broken-links take one argument, given either as the
+// next token or as --name=PAT.
+
+var sizeRegex = regexp.MustCompile(`^(\+|\-)?([[:digit:]]+)(c|C|k|K|m|M|g|G|t|T)?$`)
+
+var typeMap = map[string]Bits{
+	F_FILE:    FB_TYPE_F,
+	F_DIR:     FB_TYPE_D,
+	F_SYMLINK: FB_TYPE_L,
+}
+
+var noArgPrimaries = map[string]bool{
+	"nouser":       true,
+	"broken-links": true,
+}
+
+var timeMap = map[string]Bits{
+	"atime": FB_ATIME,
+	"ctime": FB_CTIME,
+	"mtime": FB_MTIME,
+	"btime": FB_BTIME,
+}
+
+// ParseSize ... 4k, -4k, +4k are valid. No unit means bytes.
+func ParseSize(s string) (op string, size int64, err error) {
+	out := sizeRegex.FindStringSubmatch(s)
+	if len(out) == 0 {
+		return "", 0, fmt.Errorf("can't parse size: %s", s)
+	}
+
+	switch out[1] {
+	case "+":
+		op = GREAT_THAN
+	case "-":
+		op = LESS_THAN
+	default:
+		op = EQUAL
+	}
+
+	// no unit is given, default to c
+	if out[3] == "" {
+		out[3] = "c"
+	}
+	size = util.StrBytes(out[2] + out[3])
+	return
+}
+
+// ParseType ... one of {dfl}
+func ParseType(s string) (Bits, error) {
+	flag, ok := typeMap[s]
+	if !ok {
+		return 0, fmt.Errorf("can't parse file type: %s. Must be one of {dfl}", s)
+	}
+	return flag, nil
+}
+
+// ParseTimeRange ... 7d (or +7d) means older than 7 days,
+// -7d means within the last 7 days. A bounded range is given as
+// 30d..90d, meaning older than 30 days but not older than 90 days.
+// Either end of a range can be left out.
+func ParseTimeRange(s string, now time.Time) (r TimeRange, err error) {
+	if i := strings.Index(s, ".."); i >= 0 {
+		return parseBoundedRange(s[:i], s[i+2:], now)
+	}
+
+	du, neg, err := util.ParseDuration(s)
+	if err != nil {
+		return r, fmt.Errorf("wrong time format: %s", s)
+	}
+	if neg {
+		r.End = now
+		r.Start = now.Add(-du)
+	} else {
+		r.Start = time.Unix(0, 0)
+		r.End = now.Add(-du)
+	}
+	return
+}
+
+func parseBoundedRange(lo string, hi string, now time.Time) (r TimeRange, err error) {
+	orig := lo + ".." + hi
+	r = TimeRange{Start: time.Unix(0, 0), End: now}
+
+	var lower, upper time.Duration
+	if lo != "" {
+		if lower, err = parseAge(lo); err != nil {
+			return r, fmt.Errorf("wrong time format: %s", orig)
+		}
+		r.End = now.Add(-lower)
+	}
+	if hi != "" {
+		if upper, err = parseAge(hi); err != nil {
+			return r, fmt.Errorf("wrong time format: %s", orig)
+		}
+		r.Start = now.Add(-upper)
+	}
+	if lo == "" && hi == "" || lo != "" && hi != "" && lower > upper {
+		return r, fmt.Errorf("wrong time range: %s", orig)
+	}
+	return
+}
+
+// parseAge ... an unsigned duration, ends of a range can't be negated
+func parseAge(s string) (time.Duration, error) {
+	if strings.HasPrefix(s, "-") || strings.HasPrefix(s, "+") {
+		return 0, fmt.Errorf("signed duration in range: %s", s)
+	}
+	du, _, err := util.ParseDuration(s)
+	return du, err
+}
+
+func parseID(s string) (uint32, error) {
+	id, err := strconv.ParseUint(s, 10, 32)
+	return uint32(id), err
+}
+
+// ParsePerm ... 644 or u=rw,go=r match exactly, -022 or -g+w,o+w
+// match if all of the bits are set, /022 or /g+w,o+w if any of them
+func ParsePerm(s string) (op string, mode uint32, err error) {
+	op = PERM_EXACT
+	perm := s
+	if strings.HasPrefix(s, PERM_ALL) || strings.HasPrefix(s, PERM_ANY) {
+		op = s[:1]
+		perm = s[1:]
+	}
+
+	if m, err := strconv.ParseUint(perm, 8, 32); err == nil && m <= 07777 {
+		return op, uint32(m), nil
+	}
+
+	mode, err = parseSymbolicPerm(perm)
+	if err != nil {
+		return "", 0, fmt.Errorf("can't parse mode: %s", s)
+	}
+	return op, mode, nil
+}
+
+// parseSymbolicPerm ... [ugoa]*[+=][rwxst]* clauses separated by ","
+func parseSymbolicPerm(s string) (mode uint32, err error) {
+	for _, clause := range strings.Split(s, ",") {
+		i := strings.IndexAny(clause, "+=")
+		if i < 0 {
+			return 0, fmt.Errorf("missing operator in %s", clause)
+		}
+		who := clause[:i]
+		if who == "" {
+			who = "a"
+		}
+		var mask uint32
+		for _, c := range who {
+			switch c {
+			case 'u':
+				mask |= 04700
+			case 'g':
+				mask |= 02070
+			case 'o':
+				mask |= 01007
+			case 'a':
+				mask |= 07777
+			default:
+				return 0, fmt.Errorf("bad who in %s", clause)
+			}
+		}
+		var bits uint32
+		for _, c := range clause[i+1:] {
+			switch c {
+			case 'r':
+				bits |= 0444
+			case 'w':
+				bits |= 0222
+			case 'x':
+				bits |= 0111
+			case 's':
+				bits |= 06000
+			case 't':
+				bits |= 01000
+			default:
+				return 0, fmt.Errorf("bad permission in %s", clause)
+			}
+		}
+		mode |= mask & bits
+	}
+	return mode, nil
+}
+
+type exprParser struct {
+	findc  *FindControl
+	tokens []string
+	pos    int
+	now    time.Time
+}
+
+// IsExprToken ... true if tok is an operator or a primary
+func IsExprToken(tok string) bool {
+	switch tok {
+	case "(", ")", "!", "-o", "--or", "-a", "--and", "--not":
+		return true
+	}
+	return IsPrimary(tok)
+}
+
+// IsPrimary ... true if tok is a primary
+func IsPrimary(tok string) bool {
+	_, ok := primaryName(tok)
+	return ok
+}
+
+// TakesArg ... true if tok is a primary that takes an argument
+func TakesArg(tok string) bool {
+	name, ok := primaryName(tok)
+	return ok && !noArgPrimaries[name]
+}
+
+// primaryName ... "--name" or "-name" gives "name"
+func primaryName(tok string) (string, bool) {
+	if !strings.HasPrefix(tok, "-") {
+		return "", false
+	}
+	name := strings.TrimLeft(tok, "-")
+	if i := strings.Index(name, "="); i >= 0 {
+		name = name[:i]
+	}
+	switch name {
+	case "name", "size", "type", "atime", "ctime", "mtime", "btime",
+		"user", "uid", "group", "gid", "perm":
+		return name, true
+	}
+	return name, noArgPrimaries[name]
+}
+
+// ParseExpr ... build the Node tree for tokens, and record in
+// findc.Flags which kind of primaries were used. If no --type is
+// given, the expression is restricted to regular files.
+func ParseExpr(findc *FindControl, tokens []string) (Node, error) {
+	p := &exprParser{findc: findc, now: time.Now()}
+	for _, tok := range tokens {
+		if _, ok := primaryName(tok); ok && strings.Contains(tok, "=") {
+			i := strings.Index(tok, "=")
+			p.tokens = append(p.tokens, tok[:i], tok[i+1:])
+		} else {
+			p.tokens = append(p.tokens, tok)
+		}
+	}
+
+	var node Node
+	if len(p.tokens) != 0 {
+		var err error
+		node, err = p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.pos < len(p.tokens) {
+			return nil, fmt.Errorf("unexpected %q in expression", p.tokens[p.pos])
+		}
+	}
+
+	// files only, unless a type is asked for, --broken-links implies one
+	if !Has(findc.Flags, FB_TYPE_D|FB_TYPE_F|FB_TYPE_L|FB_BROKEN) {
+		findc.Flags = Set(findc.Flags, FB_TYPE_F)
+		if node == nil {
+			node = Type{FB_TYPE_F}
+		} else {
+			node = And{Type{FB_TYPE_F}, node}
+		}
+	}
+	return node, nil
+}
+
+func (p *exprParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *exprParser) parseOr() (Node, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek() == "-o" || p.peek() == "--or" {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = Or{left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (Node, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		tok := p.peek()
+		if tok == "-a" || tok == "--and" {
+			p.pos++
+		} else if tok == "" || tok == ")" || tok == "-o" || tok == "--or" {
+			return left, nil
+		}
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = And{left, right}
+	}
+}
+
+func (p *exprParser) parseUnary() (Node, error) {
+	tok := p.peek()
+	switch tok {
+	case "":
+		return nil, fmt.Errorf("expression ends unexpectedly")
+	case "!", "--not":
+		p.pos++
+		elem, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return Not{elem}, nil
+	case "(":
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ")" {
+			return nil, fmt.Errorf("missing ')' in expression")
+		}
+		p.pos++
+		return node, nil
+	}
+
+	name, ok := primaryName(tok)
+	if !ok {
+		return nil, fmt.Errorf("unexpected %q in expression", tok)
+	}
+	p.pos++
+	if noArgPrimaries[name] {
+		return p.parsePrimary(name, "")
+	}
+	if p.pos >= len(p.tokens) {
+		return nil, fmt.Errorf("missing argument to %s", tok)
+	}
+	arg := p.tokens[p.pos]
+	p.pos++
+	return p.parsePrimary(name, arg)
+}
+
+func (p *exprParser) parsePrimary(name string, arg string) (Node, error) {
+	findc := p.findc
+	switch name {
+	case "name":
+		findc.Name = arg
+		findc.Flags = Set(findc.Flags, FB_NAME)
+		return Name{arg}, nil
+	case "size":
+		op, size, err := ParseSize(arg)
+		if err != nil {
+			return nil, err
+		}
+		// kept for the directory level size check
+		findc.SizeOp = op
+		findc.Size = size
+		findc.Flags = Set(findc.Flags, FB_SIZE)
+		return Size{op, size}, nil
+	case "type":
+		flag, err := ParseType(arg)
+		if err != nil {
+			return nil, err
+		}
+		findc.Flags = Set(findc.Flags, flag)
+		return Type{flag}, nil
+	case "atime", "ctime", "mtime", "btime":
+		r, err := ParseTimeRange(arg, p.now)
+		if err != nil {
+			return nil, err
+		}
+		flag := timeMap[name]
+		switch flag {
+		case FB_ATIME:
+			findc.ATime = r
+		case FB_CTIME:
+			findc.CTime = r
+		case FB_MTIME:
+			findc.MTime = r
+		case FB_BTIME:
+			findc.BTime = r
+		}
+		findc.Flags = Set(findc.Flags, flag)
+		return Time{flag, r}, nil
+	case "user", "uid":
+		var uid uint32
+		var err error
+		if name == "uid" {
+			uid, err = parseID(arg)
+		} else {
+			uid, err = util.LookupUID(arg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unknown user: %s", arg)
+		}
+		findc.Flags = Set(findc.Flags, FB_USER)
+		return Owner{FB_USER, uid}, nil
+	case "group", "gid":
+		var gid uint32
+		var err error
+		if name == "gid" {
+			gid, err = parseID(arg)
+		} else {
+			gid, err = util.LookupGID(arg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("unknown group: %s", arg)
+		}
+		findc.Flags = Set(findc.Flags, FB_GROUP)
+		return Owner{FB_GROUP, gid}, nil
+	case "nouser":
+		findc.Flags = Set(findc.Flags, FB_NOUSER)
+		return NoUser{}, nil
+	case "broken-links":
+		findc.Flags = Set(findc.Flags, FB_BROKEN)
+		return BrokenLink{}, nil
+	case "perm":
+		op, mode, err := ParsePerm(arg)
+		if err != nil {
+			return nil, err
+		}
+		findc.Flags = Set(findc.Flags, FB_PERM)
+		return Perm{op, mode}, nil
+	}
+	return nil, fmt.Errorf("unknown primary --%s", name)
+}
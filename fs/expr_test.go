@@ -0,0 +1,175 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeInfo ... a FileInfo without a file behind it
+type fakeInfo struct {
+	name string
+	size int64
+	mode os.FileMode
+	stat syscall.Stat_t
+}
+
+func (f fakeInfo) Name() string       { return f.name }
+func (f fakeInfo) Size() int64        { return f.size }
+func (f fakeInfo) Mode() os.FileMode  { return f.mode }
+func (f fakeInfo) ModTime() time.Time { return time.Time{} }
+func (f fakeInfo) IsDir() bool        { return f.mode.IsDir() }
+func (f fakeInfo) Sys() interface{}   { return &f.stat }
+
+func evalExpr(t *testing.T, tokens []string, fi os.FileInfo) bool {
+	findc := &FindControl{}
+	node, err := ParseExpr(findc, tokens)
+	assert.Nil(t, err)
+	return node.Eval(findc, "", fi)
+}
+
+func TestParseExpr(t *testing.T) {
+	h5 := fakeInfo{name: "a.h5", size: 100}
+	nc := fakeInfo{name: "b.nc", size: 5000}
+	txt := fakeInfo{name: "c.txt", size: 100}
+	dir := fakeInfo{name: "d.h5", mode: os.ModeDir}
+
+	or := []string{"(", "--name", "*.h5", "-o", "--name", "*.nc", ")"}
+	assert.True(t, evalExpr(t, or, h5))
+	assert.True(t, evalExpr(t, or, nc))
+	assert.False(t, evalExpr(t, or, txt))
+	// no --type means regular files only
+	assert.False(t, evalExpr(t, or, dir))
+
+	and := append(or, "!", "--size=+1k")
+	assert.True(t, evalExpr(t, and, h5))
+	assert.False(t, evalExpr(t, and, nc))
+
+	assert.True(t, evalExpr(t, []string{"--type", "d", "-a", "--name", "d*"}, dir))
+	assert.True(t, evalExpr(t, []string{"--not", "--name", "*.h5", "--or", "--size", "100"}, h5))
+	assert.True(t, evalExpr(t, nil, txt))
+}
+
+func TestParseExprError(t *testing.T) {
+	bad := [][]string{
+		{"(", "--name", "x"},
+		{"--name"},
+		{"--size", "abc"},
+		{"--type", "q"},
+		{"--name", "x", ")"},
+		{"-o", "--name", "x"},
+	}
+	for _, tokens := range bad {
+		_, err := ParseExpr(&FindControl{}, tokens)
+		assert.NotNil(t, err, "%v", tokens)
+	}
+}
+
+func TestParseTimeRange(t *testing.T) {
+	now := time.Now()
+	day := 24 * time.Hour
+
+	r, err := ParseTimeRange("30d..90d", now)
+	assert.Nil(t, err)
+	assert.True(t, r.Contains(now.Add(-60*day)))
+	assert.False(t, r.Contains(now.Add(-10*day)))
+	assert.False(t, r.Contains(now.Add(-100*day)))
+
+	r, err = ParseTimeRange("..7d", now)
+	assert.Nil(t, err)
+	assert.True(t, r.Contains(now.Add(-1*day)))
+	assert.False(t, r.Contains(now.Add(-8*day)))
+
+	r, err = ParseTimeRange("7d", now)
+	assert.Nil(t, err)
+	assert.True(t, r.Contains(now.Add(-8*day)))
+	assert.False(t, r.Contains(now.Add(-1*day)))
+
+	r, err = ParseTimeRange("-7d", now)
+	assert.Nil(t, err)
+	assert.True(t, r.Contains(now.Add(-1*day)))
+
+	for _, bad := range []string{"..", "90d..30d", "-1d..3d", "xd..3d"} {
+		_, err = ParseTimeRange(bad, now)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestMultipleTimePredicates(t *testing.T) {
+	findc := &FindControl{}
+	_, err := ParseExpr(findc, []string{"--atime", "90d", "--mtime", "30d..180d"})
+	assert.Nil(t, err)
+	assert.True(t, Has(findc.Flags, FB_ATIME) && Has(findc.Flags, FB_MTIME))
+	assert.Equal(t, findc.MTime.End.Sub(findc.MTime.Start), 150*24*time.Hour)
+	assert.True(t, findc.ATime.Start.Equal(time.Unix(0, 0)))
+}
+
+func TestTimeWithoutExpr(t *testing.T) {
+	f, err := ioutil.TempFile("", "pi-time")
+	assert.Nil(t, err)
+	f.Close()
+	defer os.Remove(f.Name())
+	now := time.Now()
+	old := now.Add(-60 * 24 * time.Hour)
+	assert.Nil(t, os.Chtimes(f.Name(), now, old))
+	fi, err := os.Lstat(f.Name())
+	assert.Nil(t, err)
+
+	// the time fields are honored when there is no expression
+	findc := &FindControl{Flags: FB_MTIME}
+	findc.MTime, err = ParseTimeRange("30d..90d", now)
+	assert.Nil(t, err)
+	assert.True(t, find_ioi(findc, "", fi))
+	findc.MTime, _ = ParseTimeRange("-30d", now)
+	assert.False(t, find_ioi(findc, "", fi))
+
+	findc = &FindControl{Flags: Set(FB_MTIME, FB_ATIME)}
+	findc.MTime, _ = ParseTimeRange("30d..90d", now)
+	findc.ATime, _ = ParseTimeRange("30d", now)
+	assert.False(t, find_ioi(findc, "", fi), "atime is recent")
+}
+
+func TestParsePerm(t *testing.T) {
+	cases := []struct {
+		s    string
+		op   string
+		mode uint32
+	}{
+		{"644", PERM_EXACT, 0644},
+		{"-022", PERM_ALL, 0022},
+		{"/022", PERM_ANY, 0022},
+		{"u=rw,go=r", PERM_EXACT, 0644},
+		{"-o+w", PERM_ALL, 0002},
+		{"/g+w,o+w", PERM_ANY, 0022},
+		{"+x", PERM_EXACT, 0111},
+		{"-u+s", PERM_ALL, 04000},
+	}
+	for _, c := range cases {
+		op, mode, err := ParsePerm(c.s)
+		assert.Nil(t, err, c.s)
+		assert.Equal(t, c.op, op, c.s)
+		assert.Equal(t, c.mode, mode, c.s)
+	}
+	for _, bad := range []string{"888", "q+w", "u*w", "u+z"} {
+		_, _, err := ParsePerm(bad)
+		assert.NotNil(t, err, bad)
+	}
+}
+
+func TestOwnerPermExpr(t *testing.T) {
+	fi := fakeInfo{name: "f", stat: syscall.Stat_t{Uid: 0, Gid: 4000000000, Mode: 0100666}}
+
+	assert.True(t, evalExpr(t, []string{"--uid", "0", "--perm", "-o+w"}, fi))
+	assert.True(t, evalExpr(t, []string{"--user", "0", "--gid", "4000000000"}, fi))
+	assert.False(t, evalExpr(t, []string{"--perm", "644"}, fi))
+	assert.True(t, evalExpr(t, []string{"--perm", "/111", "-o", "--perm", "666"}, fi))
+	assert.False(t, evalExpr(t, []string{"--nouser"}, fi))
+
+	fi.stat.Uid = 4000000000
+	assert.True(t, evalExpr(t, []string{"--nouser"}, fi))
+	assert.True(t, evalExpr(t, []string{"!", "--user", "0"}, fi))
+}
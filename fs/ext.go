@@ -0,0 +1,161 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// labels of ExtUsage that are not extensions. Files classified by
+// their content are labelled "(type)" too.
+const (
+	EXT_NONE  = "(none)"
+	EXT_OTHER = "(other)"
+)
+
+// ExtUsage ... Usage of a file extension
+type ExtUsage struct {
+	Ext string `json:"ext"`
+	Usage
+}
+
+// doubleExts ... archive extensions kept whole
+var doubleExts = []string{".tar.gz", ".tar.bz2", ".tar.xz", ".tar.zst"}
+
+// coreRegex ... core dumps as named by the kernel
+var coreRegex = regexp.MustCompile(`^core(\.[0-9]+)?$`)
+
+// fileExt ... lowercase extension of a file name without the dot,
+// "" if there is none
+func fileExt(name string) string {
+	lname := strings.ToLower(name)
+	if coreRegex.MatchString(lname) {
+		return "(core)"
+	}
+	for _, ext := range doubleExts {
+		if strings.HasSuffix(lname, ext) && len(lname) > len(ext) {
+			return ext[1:]
+		}
+	}
+	ext := filepath.Ext(lname)
+	// a dot file such as .bashrc has no extension
+	if ext == lname || len(ext) < 2 {
+		return ""
+	}
+	return ext[1:]
+}
+
+// magic ... a signature at some offset of a file
+type magic struct {
+	offset int
+	sig    []byte
+	label  string
+}
+
+var magics = []magic{
+	{0, []byte("\x89HDF\r\n\x1a\n"), "(hdf5)"},
+	{0, []byte("CDF\x01"), "(netcdf)"},
+	{0, []byte("CDF\x02"), "(netcdf)"},
+	{0, []byte("CDF\x05"), "(netcdf)"},
+	{0, []byte("\x1f\x8b"), "(gzip)"},
+	{0, []byte("BZh"), "(bzip2)"},
+	{0, []byte("\xfd7zXZ\x00"), "(xz)"},
+	{0, []byte("\x28\xb5\x2f\xfd"), "(zstd)"},
+	{0, []byte("PK\x03\x04"), "(zip)"},
+	{0, []byte("%PDF"), "(pdf)"},
+	{0, []byte("#!"), "(script)"},
+	{257, []byte("ustar"), "(tar)"},
+}
+
+// sniffType ... classify a file by its first bytes, EXT_NONE if unknown
+func sniffType(fname string) string {
+	f, err := os.Open(fname)
+	if err != nil {
+		return EXT_NONE
+	}
+	defer f.Close()
+	buf := make([]byte, 512)
+	n, _ := io.ReadFull(f, buf)
+	return sniffBytes(buf[:n])
+}
+
+func sniffBytes(buf []byte) string {
+	if bytes.HasPrefix(buf, []byte("\x7fELF")) {
+		return sniffELF(buf)
+	}
+	for _, m := range magics {
+		if len(buf) >= m.offset+len(m.sig) && bytes.Equal(buf[m.offset:m.offset+len(m.sig)], m.sig) {
+			return m.label
+		}
+	}
+	return EXT_NONE
+}
+
+// sniffELF ... tell core dumps and objects from other ELF files by e_type
+func sniffELF(buf []byte) string {
+	if len(buf) < 18 {
+		return "(elf)"
+	}
+	var order binary.ByteOrder = binary.LittleEndian
+	if buf[5] == 2 {
+		order = binary.BigEndian
+	}
+	switch order.Uint16(buf[16:18]) {
+	case 1:
+		return "(object)"
+	case 4:
+		return "(core)"
+	}
+	return "(elf)"
+}
+
+// addExtUsage ... account a regular file to its extension in m
+func addExtUsage(m map[string]*Usage, ext string, fi os.FileInfo, blocks int64) {
+	u, ok := m[ext]
+	if !ok {
+		u = new(Usage)
+		m[ext] = u
+	}
+	u.Files++
+	u.Bytes += fi.Size()
+	u.Allocated += 512 * blocks
+}
+
+// mergeExtUsage ... fold per-directory usage into the totals
+func mergeExtUsage(dst map[string]*Usage, src map[string]*Usage) {
+	for ext, u := range src {
+		if d, ok := dst[ext]; ok {
+			d.add(u)
+		} else {
+			dst[ext] = u
+		}
+	}
+}
+
+// TopExtUsage ... the k extensions using the most space, largest first,
+// then the rest summed up as EXT_OTHER. All of them if k is 0.
+func TopExtUsage(ws *WalkStat, k int) []ExtUsage {
+	list := make([]ExtUsage, 0, len(ws.ExtUsage))
+	for ext, u := range ws.ExtUsage {
+		list = append(list, ExtUsage{Ext: ext, Usage: *u})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Allocated != list[j].Allocated {
+			return list[i].Allocated > list[j].Allocated
+		}
+		return list[i].Ext < list[j].Ext
+	})
+	if k <= 0 || len(list) <= k {
+		return list
+	}
+	other := ExtUsage{Ext: EXT_OTHER}
+	for _, e := range list[k:] {
+		other.add(&e.Usage)
+	}
+	return append(list[:k], other)
+}
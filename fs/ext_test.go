@@ -0,0 +1,95 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileExt(t *testing.T) {
+	for name, ext := range map[string]string{
+		"data.H5":        "h5",
+		"run.tar.gz":     "tar.gz",
+		"log.gz":         "gz",
+		".bashrc":        "",
+		"Makefile":       "",
+		"trailing.":      "",
+		"core":           "(core)",
+		"core.12345":     "(core)",
+		"core.c":         "c",
+		"a.b.tar.zst":    "tar.zst",
+		"archive.tar.gz": "tar.gz",
+	} {
+		assert.Equal(t, ext, fileExt(name), name)
+	}
+}
+
+func TestSniffBytes(t *testing.T) {
+	elf := func(class byte, order byte, etype uint16) []byte {
+		buf := make([]byte, 64)
+		copy(buf, "\x7fELF")
+		buf[4], buf[5] = class, order
+		if order == 2 {
+			buf[16], buf[17] = byte(etype>>8), byte(etype)
+		} else {
+			buf[16], buf[17] = byte(etype), byte(etype>>8)
+		}
+		return buf
+	}
+	tar := make([]byte, 512)
+	copy(tar[257:], "ustar")
+
+	assert.Equal(t, "(hdf5)", sniffBytes([]byte("\x89HDF\r\n\x1a\n\x00\x00")))
+	assert.Equal(t, "(netcdf)", sniffBytes([]byte("CDF\x01\x00")))
+	assert.Equal(t, "(gzip)", sniffBytes([]byte("\x1f\x8b\x08")))
+	assert.Equal(t, "(tar)", sniffBytes(tar))
+	assert.Equal(t, "(core)", sniffBytes(elf(2, 1, 4)))
+	assert.Equal(t, "(core)", sniffBytes(elf(2, 2, 4)))
+	assert.Equal(t, "(object)", sniffBytes(elf(2, 1, 1)))
+	assert.Equal(t, "(elf)", sniffBytes(elf(2, 1, 2)))
+	assert.Equal(t, EXT_NONE, sniffBytes([]byte("plain text")))
+	assert.Equal(t, EXT_NONE, sniffBytes(nil))
+}
+
+func TestTopExtUsage(t *testing.T) {
+	ws := &WalkStat{ExtUsage: map[string]*Usage{
+		"h5":  {Files: 1, Bytes: 100, Allocated: 4096},
+		"txt": {Files: 5, Bytes: 50, Allocated: 20480},
+		"c":   {Files: 2, Bytes: 20, Allocated: 8192},
+		"o":   {Files: 3, Bytes: 30, Allocated: 8192},
+	}}
+	all := TopExtUsage(ws, 0)
+	assert.Equal(t, 4, len(all))
+	assert.Equal(t, "txt", all[0].Ext)
+	assert.Equal(t, "c", all[1].Ext)
+
+	top := TopExtUsage(ws, 2)
+	assert.Equal(t, 3, len(top))
+	assert.Equal(t, EXT_OTHER, top[2].Ext)
+	assert.Equal(t, Usage{Files: 4, Bytes: 130, Allocated: 12288}, top[2].Usage)
+}
+
+func TestWalkByExt(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	write := func(f string, data []byte) {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(root, f), data, 0644))
+	}
+	write("a/run.tar.gz", make([]byte, 100))
+	write("b/result", []byte("\x89HDF\r\n\x1a\nmore"))
+	write("b/c/empty", nil)
+
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{DoByExt: true}, ws)
+	assert.Equal(t, int64(6), ws.ExtUsage[EXT_NONE].Files)
+	assert.Equal(t, int64(100), ws.ExtUsage["tar.gz"].Bytes)
+
+	ws = &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{DoByExt: true, SniffExt: true}, ws)
+	assert.Equal(t, int64(1), ws.ExtUsage["(hdf5)"].Files)
+	// f1 to f4 are zeros, the empty file isn't read
+	assert.Equal(t, int64(5), ws.ExtUsage[EXT_NONE].Files)
+}
@@ -10,13 +10,40 @@ const (
 	EQUAL      = "=="
 )
 
+const (
+	PERM_EXACT = "="
+	PERM_ALL   = "-"
+	PERM_ANY   = "/"
+)
+
+// FindControl ... what find looks for. A time range is checked when
+// its FB_*TIME flag is set, by Expr if there is one, else by find_ioi.
 type FindControl struct {
 	Size       int64
 	SizeOp     string
 	Apparent   bool
 	Name       string
-	StartTime  time.Time
-	EndTime    time.Time
+	ATime      TimeRange
+	CTime      TimeRange
+	MTime      TimeRange
+	BTime      TimeRange
 	Flags      Bits
 	DeleteFlag bool
+	Expr       Node // if set, decides alone what is found
+}
+
+// DirSizes ... true if directories are found by the size of their files
+func (findc *FindControl) DirSizes() bool {
+	return Has(findc.Flags, FB_TYPE_D) && Has(findc.Flags, FB_SIZE)
+}
+
+// TimeRange ... an open interval (Start, End)
+type TimeRange struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Contains ... true if t falls in the range
+func (r TimeRange) Contains(t time.Time) bool {
+	return t.After(r.Start) && t.Before(r.End)
 }
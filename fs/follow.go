@@ -0,0 +1,95 @@
+package fs
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// symlinks that can't be followed, or lead out of the walk
+const (
+	LINK_OK      = iota
+	LINK_BROKEN  // the target doesn't exist
+	LINK_LOOP    // loops, or leads back to an ancestor directory
+	LINK_OUTSIDE // the target is outside of the root of the walk
+)
+
+// linkError ... LINK_BROKEN or LINK_LOOP for an error stating a link,
+// LINK_OK if it doesn't tell
+func linkError(err error) int {
+	switch {
+	case errors.Is(err, syscall.ELOOP):
+		return LINK_LOOP
+	case os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR):
+		return LINK_BROKEN
+	}
+	return LINK_OK
+}
+
+// CheckLink ... classify the symlink at fname, in dir. root is the
+// resolved root of the walk, the target isn't checked against it if "".
+func CheckLink(root string, dir string, fname string) int {
+	target, err := os.Stat(fname)
+	if err != nil {
+		return linkError(err)
+	}
+	if target.IsDir() && isLinkLoop(fname, dir) {
+		return LINK_LOOP
+	}
+	if root != "" && isOutside(root, fname) {
+		return LINK_OUTSIDE
+	}
+	return LINK_OK
+}
+
+// isOutside ... true if the target of link is not under root
+func isOutside(root string, link string) bool {
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return false
+	}
+	return target != root && !isUnder(root, target)
+}
+
+// addLink ... record a symlink of kind in res
+func (res *ScanResult) addLink(kind int, fname string) {
+	switch kind {
+	case LINK_BROKEN:
+		res.brokenLinks = append(res.brokenLinks, fname)
+	case LINK_LOOP:
+		res.loopLinks = append(res.loopLinks, fname)
+	case LINK_OUTSIDE:
+		res.outLinks = append(res.outLinks, fname)
+	}
+}
+
+// followLink ... with WalkControl.Follow, the target of the symlink at
+// fname stands for it. A broken or looping link is recorded in res,
+// false is returned and the link is counted as such.
+func followLink(res *ScanResult, fname string) (os.FileInfo, bool) {
+	target, err := os.Stat(fname)
+	if err == nil {
+		return target, true
+	}
+	if kind := linkError(err); kind != LINK_OK {
+		res.addLink(kind, fname)
+	} else {
+		log.Debugf("Can't follow %s: %v", fname, err)
+	}
+	return nil, false
+}
+
+// isLinkLoop ... true if link, a symlink to a directory in dir, leads
+// back to dir or one of its ancestors
+func isLinkLoop(link string, dir string) bool {
+	target, err := filepath.EvalSymlinks(link)
+	if err != nil {
+		return false
+	}
+	dir, err = filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false
+	}
+	return target == dir || isUnder(target, dir)
+}
@@ -0,0 +1,121 @@
+package fs
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalkFollow(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	other := createTree(t)
+	defer os.RemoveAll(other)
+	outer, err := ioutil.TempDir("", "pi-outer")
+	assert.Nil(t, err)
+	defer os.RemoveAll(outer)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(outer, "g"), make([]byte, 500), 0644))
+	// root/{f1, a/{f2, f3}, b/c/f4}, plus
+	// a/up -> root, b/other -> other tree, b/f1 -> f1,
+	// b/broken -> nowhere, l1 <-> l2
+	link := func(target string, name string) {
+		assert.Nil(t, os.Symlink(target, filepath.Join(root, name)))
+	}
+	link(root, "a/up")
+	link(other, "b/other")
+	link(filepath.Join(root, "f1"), "b/f1")
+	link(filepath.Join(outer, "g"), "a/g1")
+	link(filepath.Join(outer, "g"), "a/g2")
+	link("nowhere", "b/broken")
+	link("l2", "l1")
+	link("l1", "l2")
+
+	plain := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{}, plain)
+	assert.Equal(t, int64(4), plain.TotFileCnt)
+	assert.Equal(t, int64(8), plain.TotSymlinkCnt)
+
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{Follow: true}, ws)
+	// the other tree is walked, f1 and g are counted once
+	assert.Equal(t, int64(9), ws.TotFileCnt)
+	assert.Equal(t, 2*plain.TotFileSize+500, ws.TotFileSize)
+	assert.Equal(t, int64(2), ws.TotLinkCnt)
+	// only the file reached through links out of the root is kept
+	assert.Equal(t, 1, ws.Links.Len())
+	assert.Equal(t, []string{filepath.Join(root, "b/broken")}, ws.BrokenLinks)
+	assert.Equal(t, []string{
+		filepath.Join(root, "a/up"),
+		filepath.Join(root, "l1"),
+		filepath.Join(root, "l2"),
+	}, ws.LoopLinks)
+	// only the links that can't be followed are left
+	assert.Equal(t, int64(3), ws.TotSymlinkCnt)
+}
+
+func TestCheckpointFollow(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+
+	wc := &WalkControl{Follow: true}
+	ws := &WalkStat{RootPath: root, BrokenLinks: []string{"/r/x"}}
+	initWalkStat(wc, ws)
+	fi, err := os.Stat(root)
+	assert.Nil(t, err)
+
+	cp := NewCheckpoint(wc, ws, nil, 0)
+	wc2, ws2 := &WalkControl{}, &WalkStat{}
+	cp.Restore(wc2, ws2)
+	assert.True(t, wc2.Follow)
+	assert.Equal(t, []string{"/r/x"}, ws2.BrokenLinks)
+	// the root is walked already
+	assert.False(t, ws2.visited.Claim(inodeKey(fi)))
+}
+
+func TestWalkCheckLinks(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	other := createTree(t)
+	defer os.RemoveAll(other)
+	outer, err := ioutil.TempDir("", "pi-outer")
+	assert.Nil(t, err)
+	defer os.RemoveAll(outer)
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(outer, "g"), make([]byte, 500), 0644))
+	link := func(target string, name string) {
+		assert.Nil(t, os.Symlink(target, filepath.Join(root, name)))
+	}
+	link("../..", "b/c/up")
+	link(other, "b/other")
+	link("f1", "f1link")
+	link("nowhere", "a/broken")
+	link("f2/x", "a/notdir")
+
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{CheckLinks: true}, ws)
+	assert.Equal(t, []string{
+		filepath.Join(root, "a/broken"),
+		filepath.Join(root, "a/notdir"),
+	}, ws.BrokenLinks)
+	assert.Equal(t, []string{filepath.Join(root, "b/c/up")}, ws.LoopLinks)
+	assert.Equal(t, []string{filepath.Join(root, "b/other")}, ws.OutsideLinks)
+	assert.Equal(t, int64(5), ws.TotSymlinkCnt)
+}
+
+func TestBrokenLinkExpr(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	assert.Nil(t, os.Symlink("nowhere", filepath.Join(root, "broken")))
+	assert.Nil(t, os.Symlink("f1", filepath.Join(root, "good")))
+
+	findc := &FindControl{}
+	node, err := ParseExpr(findc, []string{"--broken-links"})
+	assert.Nil(t, err)
+	for name, want := range map[string]bool{"broken": true, "good": false, "f1": false} {
+		fi, err := os.Lstat(filepath.Join(root, name))
+		assert.Nil(t, err)
+		assert.Equal(t, want, node.Eval(findc, root, fi), name)
+	}
+}
@@ -0,0 +1,107 @@
+package fs
+
+import (
+	"os"
+	"sync"
+	"syscall"
+)
+
+// linkShards ... number of independently locked parts of a LinkSet,
+// so workers rarely wait on each other
+const linkShards = 64
+
+// LinkKey ... identity of an inode
+type LinkKey struct {
+	Dev uint64 `json:"dev"`
+	Ino uint64 `json:"ino"`
+}
+
+// LinkSet ... inodes with more than one link seen by a walk, safe for
+// concurrent use. An inode is claimed by the first worker that sees it,
+// the claim is committed once its directory is merged into the totals:
+// only committed inodes are saved in a checkpoint, a directory walked
+// again on resume claims its inodes again.
+type LinkSet struct {
+	shards [linkShards]struct {
+		sync.Mutex
+		m map[LinkKey]bool // true once committed
+	}
+}
+
+// NewLinkSet ... an empty set
+func NewLinkSet() *LinkSet {
+	s := new(LinkSet)
+	for i := range s.shards {
+		s.shards[i].m = make(map[LinkKey]bool)
+	}
+	return s
+}
+
+func (s *LinkSet) shard(k LinkKey) int {
+	return int((k.Ino ^ k.Dev) % linkShards)
+}
+
+// Claim ... true if k is seen for the first time
+func (s *LinkSet) Claim(k LinkKey) bool {
+	sh := &s.shards[s.shard(k)]
+	sh.Lock()
+	defer sh.Unlock()
+	if _, seen := sh.m[k]; seen {
+		return false
+	}
+	sh.m[k] = false
+	return true
+}
+
+// Commit ... mark claimed inodes as accounted for
+func (s *LinkSet) Commit(keys []LinkKey) {
+	for _, k := range keys {
+		sh := &s.shards[s.shard(k)]
+		sh.Lock()
+		sh.m[k] = true
+		sh.Unlock()
+	}
+}
+
+// Committed ... the committed inodes, in no particular order
+func (s *LinkSet) Committed() []LinkKey {
+	var keys []LinkKey
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.Lock()
+		for k, done := range sh.m {
+			if done {
+				keys = append(keys, k)
+			}
+		}
+		sh.Unlock()
+	}
+	return keys
+}
+
+// Len ... number of inodes in the set
+func (s *LinkSet) Len() int {
+	var n int
+	for i := range s.shards {
+		sh := &s.shards[i]
+		sh.Lock()
+		n += len(sh.m)
+		sh.Unlock()
+	}
+	return n
+}
+
+// linkKey ... identity of fi, if it is a file with more than one link
+func linkKey(fi os.FileInfo) (LinkKey, bool) {
+	stat, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok || stat.Nlink < 2 {
+		return LinkKey{}, false
+	}
+	return inodeKey(fi), true
+}
+
+// inodeKey ... identity of the inode of fi
+func inodeKey(fi os.FileInfo) LinkKey {
+	stat := fi.Sys().(*syscall.Stat_t)
+	return LinkKey{Dev: uint64(stat.Dev), Ino: uint64(stat.Ino)}
+}
@@ -0,0 +1,86 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLinkSetConcurrent(t *testing.T) {
+	s := NewLinkSet()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	claimed := 0
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := uint64(0); i < 1000; i++ {
+				if s.Claim(LinkKey{Dev: 1, Ino: i}) {
+					mu.Lock()
+					claimed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1000, claimed)
+	assert.Equal(t, 1000, s.Len())
+
+	assert.Equal(t, 0, len(s.Committed()))
+	s.Commit([]LinkKey{{1, 1}, {1, 2}})
+	assert.Equal(t, 2, len(s.Committed()))
+	assert.False(t, s.Claim(LinkKey{1, 1}))
+}
+
+func TestWalkHardlinks(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	// root/{f1, a/{f2, f3}, b/c/f4}, f2 linked twice more
+	f2 := filepath.Join(root, "a/f2")
+	assert.Nil(t, os.Link(f2, filepath.Join(root, "a/f2.link")))
+	assert.Nil(t, os.Link(f2, filepath.Join(root, "b/c/f2.link")))
+	fi, err := os.Lstat(f2)
+	assert.Nil(t, err)
+	size := FileSize(root, fi)
+
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{DoTree: true}, ws)
+	assert.Equal(t, int64(4), ws.TotFileCnt)
+	assert.Equal(t, int64(2), ws.TotLinkCnt)
+	assert.Equal(t, 2*size, ws.TotLinkSize)
+	assert.Equal(t, 1, ws.Links.Len())
+	// 9 entries, the 2 extra links don't count as inodes
+	assert.Equal(t, int64(7), ws.Tree.Dir(root).Inodes)
+
+	counted := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{CountLinks: true}, counted)
+	assert.Equal(t, int64(6), counted.TotFileCnt)
+	assert.Equal(t, ws.TotFileSize+ws.TotLinkSize, counted.TotFileSize)
+	assert.Equal(t, int64(0), counted.TotLinkCnt)
+	assert.Nil(t, counted.Links)
+}
+
+func TestCheckpointHardlinks(t *testing.T) {
+	wc := &WalkControl{}
+	ws := &WalkStat{RootPath: "/r", TotLinkCnt: 3, TotLinkSize: 300}
+	initWalkStat(wc, ws)
+	ws.Links.Claim(LinkKey{1, 1})
+	ws.Links.Claim(LinkKey{1, 2})
+	ws.Links.Commit([]LinkKey{{1, 1}})
+
+	cp := NewCheckpoint(wc, ws, nil, 0)
+	assert.Equal(t, []LinkKey{{1, 1}}, cp.Links)
+
+	wc2, ws2 := &WalkControl{}, &WalkStat{}
+	cp.Restore(wc2, ws2)
+	assert.Equal(t, int64(3), ws2.TotLinkCnt)
+	assert.Equal(t, int64(300), ws2.TotLinkSize)
+	// the uncommitted inode is claimed again by the walk that resumes
+	assert.False(t, ws2.Links.Claim(LinkKey{1, 1}))
+	assert.True(t, ws2.Links.Claim(LinkKey{1, 2}))
+}
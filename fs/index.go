@@ -0,0 +1,610 @@
+package fs
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fwang2/pi/util"
+)
+
+/**
+
+## Index file format
+
+An index (scan snapshot) is a sequence of gzip members, which together
+read as one gzip stream. Each member holds one or more records:
+
+	'H' magic "PIDX", version, root, creation time (unix ns)
+	'D' directory path, number of entries, then for each entry:
+	    name, os.FileMode, size, 512B blocks, uid, gid, st_mode,
+	    inode, device, nlink, atime, mtime, ctime (unix ns)
+	'E' end of a complete scan, in a member of its own
+
+Strings are a uvarint length followed by the bytes, numbers are
+(u)varints. Walk workers encode directories into per-shard buffers
+and a full shard is compressed and appended to the file as one member,
+so workers only contend on the file for the final write. New members
+can be appended to an existing index, e.g. by a later scan.
+
+A scan stopped early, by Ctrl-C or a timeout, has no 'E' record. The
+directories it didn't get to are not in it, readers must not take them
+for removed.
+
+A later scan, which starts with its own 'H' record, supersedes the
+earlier ones: readers take the header of the last scan, the records of a
+directory from the last scan that has it, and drop a directory once the
+last scan of its parent no longer lists it. A directory read in batches
+has several records in one scan.
+
+*/
+
+const (
+	IndexMagic   = "PIDX"
+	IndexVersion = 1
+
+	indexHeader   = 'H'
+	indexDir      = 'D'
+	indexEnd      = 'E'
+	indexFlushLen = 1 << 20 // raw bytes buffered per shard
+)
+
+// IndexEntry ... one entry of an index. It is an os.FileInfo whose Sys()
+// is a *syscall.Stat_t, so everything that works on a walk works on it.
+type IndexEntry struct {
+	name string
+	mode os.FileMode
+	stat syscall.Stat_t
+}
+
+func (e *IndexEntry) Name() string       { return e.name }
+func (e *IndexEntry) Size() int64        { return e.stat.Size }
+func (e *IndexEntry) Mode() os.FileMode  { return e.mode }
+func (e *IndexEntry) IsDir() bool        { return e.mode.IsDir() }
+func (e *IndexEntry) Sys() interface{}   { return &e.stat }
+func (e *IndexEntry) ModTime() time.Time { _, _, mtime := util.StatsTime(&e.stat); return mtime }
+
+type indexShard struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+// IndexWriter ... safe for concurrent use by Walk workers
+type IndexWriter struct {
+	file   *os.File
+	fmu    sync.Mutex // serializes writes to file
+	shards []indexShard
+	next   uint32
+	err    error // first write error, guarded by fmu
+
+	complete bool
+}
+
+// CreateIndex ... start a new index for root, or add to the end of an
+// existing one if append is set. shards is usually the number of workers.
+func CreateIndex(file string, root string, shards int, append bool) (*IndexWriter, error) {
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if append {
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+	if append {
+		if err := checkIndexRoot(file, root); err != nil {
+			return nil, err
+		}
+	}
+	f, err := os.OpenFile(file, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	if shards < 1 {
+		shards = 1
+	}
+	w := &IndexWriter{file: f, shards: make([]indexShard, shards)}
+
+	var hdr bytes.Buffer
+	hdr.WriteByte(indexHeader)
+	putString(&hdr, IndexMagic)
+	putUvarint(&hdr, IndexVersion)
+	putString(&hdr, root)
+	putVarint(&hdr, time.Now().UnixNano())
+	w.flush(&hdr)
+	if w.err != nil {
+		f.Close()
+		return nil, w.err
+	}
+	return w, nil
+}
+
+// Add ... record the entries of dir, as returned by ReadDir
+func (w *IndexWriter) Add(dir string, entries []os.FileInfo) {
+	i := atomic.AddUint32(&w.next, 1) % uint32(len(w.shards))
+	shard := &w.shards[i]
+
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	buf := &shard.buf
+	buf.WriteByte(indexDir)
+	putString(buf, dir)
+	putUvarint(buf, uint64(len(entries)))
+	for _, fi := range entries {
+		stat := fi.Sys().(*syscall.Stat_t)
+		atime, ctime, mtime := util.StatsTime(stat)
+		putString(buf, fi.Name())
+		putUvarint(buf, uint64(fi.Mode()))
+		putVarint(buf, fi.Size())
+		putVarint(buf, int64(stat.Blocks))
+		putUvarint(buf, uint64(stat.Uid))
+		putUvarint(buf, uint64(stat.Gid))
+		putUvarint(buf, uint64(stat.Mode))
+		putUvarint(buf, uint64(stat.Ino))
+		putUvarint(buf, uint64(stat.Dev))
+		putUvarint(buf, uint64(stat.Nlink))
+		putVarint(buf, atime.UnixNano())
+		putVarint(buf, mtime.UnixNano())
+		putVarint(buf, ctime.UnixNano())
+	}
+	if buf.Len() >= indexFlushLen {
+		w.flush(buf)
+	}
+}
+
+// flush ... compress raw records into one gzip member and append it
+func (w *IndexWriter) flush(raw *bytes.Buffer) {
+	if raw.Len() == 0 {
+		return
+	}
+	var member bytes.Buffer
+	zw := gzip.NewWriter(&member)
+	zw.Write(raw.Bytes())
+	zw.Close()
+	raw.Reset()
+
+	w.fmu.Lock()
+	defer w.fmu.Unlock()
+	if w.err != nil {
+		return
+	}
+	_, w.err = w.file.Write(member.Bytes())
+}
+
+// Complete ... the scan got through the whole tree, Close ends it with
+// an 'E' record
+func (w *IndexWriter) Complete() {
+	w.complete = true
+}
+
+// Close ... flush all shards, and report the first write error
+func (w *IndexWriter) Close() error {
+	for i := range w.shards {
+		w.shards[i].mu.Lock()
+		w.flush(&w.shards[i].buf)
+		w.shards[i].mu.Unlock()
+	}
+	if w.complete {
+		// after every directory of the scan
+		var end bytes.Buffer
+		end.WriteByte(indexEnd)
+		w.flush(&end)
+	}
+	err := w.file.Close()
+	if w.err != nil {
+		return w.err
+	}
+	return err
+}
+
+// checkIndexRoot ... an index appended to must be of the same root, a
+// missing or empty one can be of any
+func checkIndexRoot(file string, root string) error {
+	if fi, err := os.Stat(file); err != nil || fi.Size() == 0 {
+		return nil
+	}
+	r, err := openIndex(file)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if filepath.Clean(r.Root) != filepath.Clean(root) {
+		return fmt.Errorf("%s is an index of %s, not %s", file, r.Root, root)
+	}
+	return nil
+}
+
+// IndexReader ... reads the directories of an index in file order, but
+// those superseded by a later scan
+type IndexReader struct {
+	Root    string
+	Version int
+	Created time.Time
+	Partial bool // the last scan was stopped before its end
+
+	file *os.File
+	br   *bufio.Reader
+
+	scan    int            // headers read so far
+	ended   bool           // the scan read last has its 'E' record
+	latest  map[string]int // the last scan recording a directory
+	removed map[string]bool
+}
+
+// OpenIndex ... open an index and read its header. The index is read
+// through once here, to know whether its last scan is complete and what
+// later scans supersede.
+func OpenIndex(file string) (*IndexReader, error) {
+	r, err := openIndex(file)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.supersede(); err != nil {
+		r.Close()
+		return nil, fmt.Errorf("%s: %v", file, err)
+	}
+	return r, nil
+}
+
+// openIndex ... open an index at its first header
+func openIndex(file string) (*IndexReader, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	r := &IndexReader{file: f}
+	if err := r.rewind(); err != nil {
+		f.Close()
+		return nil, fmt.Errorf("%s: not an index: %v", file, err)
+	}
+	return r, nil
+}
+
+// rewind ... go back to the start of the file, past the first header
+func (r *IndexReader) rewind() error {
+	if _, err := r.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	zr, err := gzip.NewReader(bufio.NewReader(r.file))
+	if err != nil {
+		return err
+	}
+	r.br = bufio.NewReaderSize(zr, 1<<16)
+	r.scan = 0
+	tag, err := r.br.ReadByte()
+	if err == nil && tag == indexHeader {
+		err = r.readHeader()
+	} else if err == nil {
+		err = errors.New("missing header")
+	}
+	return err
+}
+
+// supersede ... find the last scan of every directory, and the
+// directories the last scan of their parent doesn't list anymore
+func (r *IndexReader) supersede() error {
+	latest := make(map[string]int)
+	listed := make(map[string]int) // the last scan listing a directory
+	for {
+		dir, entries, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		latest[dir] = r.scan
+		for _, e := range entries {
+			if e.IsDir() {
+				listed[filepath.Join(dir, e.Name())] = r.scan
+			}
+		}
+	}
+	r.Partial = !r.ended
+	if r.scan == 1 {
+		// a single scan, nothing is superseded
+		return r.rewind()
+	}
+
+	// the header of the last scan wins
+	root, version, created := r.Root, r.Version, r.Created
+	removed := make(map[string]bool)
+	var gone func(dir string) bool
+	gone = func(dir string) bool {
+		if g, ok := removed[dir]; ok {
+			return g
+		}
+		parent := filepath.Dir(dir)
+		g := false
+		if p, ok := latest[parent]; ok && dir != filepath.Clean(root) && parent != dir {
+			g = listed[dir] != p || gone(parent)
+		}
+		removed[dir] = g
+		return g
+	}
+	for dir := range latest {
+		gone(dir)
+	}
+	if err := r.rewind(); err != nil {
+		return err
+	}
+	r.Root, r.Version, r.Created = root, version, created
+	r.latest, r.removed = latest, removed
+	return nil
+}
+
+func (r *IndexReader) readHeader() error {
+	magic, err := getString(r.br)
+	if err != nil || magic != IndexMagic {
+		return errors.New("bad magic")
+	}
+	version, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		return err
+	}
+	if version != IndexVersion {
+		return fmt.Errorf("version %d, expect %d", version, IndexVersion)
+	}
+	root, err := getString(r.br)
+	if err != nil {
+		return err
+	}
+	created, err := binary.ReadVarint(r.br)
+	if err != nil {
+		return err
+	}
+	// each scan starts with a header, the last one wins
+	r.scan++
+	r.ended = false
+	r.Root = root
+	r.Version = int(version)
+	r.Created = time.Unix(0, created)
+	return nil
+}
+
+// Next ... the next directory and its entries, io.EOF at the end
+func (r *IndexReader) Next() (dir string, entries []os.FileInfo, err error) {
+	for {
+		tag, err := r.br.ReadByte()
+		if err != nil {
+			return "", nil, err
+		}
+		switch tag {
+		case indexHeader:
+			root, version, created := r.Root, r.Version, r.Created
+			if err := r.readHeader(); err != nil {
+				return "", nil, err
+			}
+			if r.latest != nil {
+				// known from supersede already
+				r.Root, r.Version, r.Created = root, version, created
+			}
+		case indexEnd:
+			r.ended = true
+		case indexDir:
+			dir, entries, err := r.readDir()
+			if err == nil && r.latest != nil &&
+				(r.latest[dir] != r.scan || r.removed[dir]) {
+				continue // superseded
+			}
+			return dir, entries, err
+		default:
+			return "", nil, fmt.Errorf("corrupted index, unknown record %q", tag)
+		}
+	}
+}
+
+func (r *IndexReader) readDir() (dir string, entries []os.FileInfo, err error) {
+	if dir, err = getString(r.br); err != nil {
+		return
+	}
+	n, err := binary.ReadUvarint(r.br)
+	if err != nil {
+		return
+	}
+	// n comes from the file, don't trust it for the allocation
+	entries = make([]os.FileInfo, 0, min64(n, 1<<16))
+	for i := uint64(0); i < n; i++ {
+		e, err := r.readEntry()
+		if err != nil {
+			return "", nil, fmt.Errorf("corrupted index in %s: %v", dir, err)
+		}
+		entries = append(entries, e)
+	}
+	return
+}
+
+func (r *IndexReader) readEntry() (*IndexEntry, error) {
+	var err error
+	e := new(IndexEntry)
+	if e.name, err = getString(r.br); err != nil {
+		return nil, err
+	}
+	// keep the first error, later reads are no-ops
+	uvarint := func() uint64 {
+		var v uint64
+		if err == nil {
+			v, err = binary.ReadUvarint(r.br)
+		}
+		return v
+	}
+	varint := func() int64 {
+		var v int64
+		if err == nil {
+			v, err = binary.ReadVarint(r.br)
+		}
+		return v
+	}
+
+	st := &e.stat
+	e.mode = os.FileMode(uvarint())
+	st.Size = varint()
+	st.Blocks = varint()
+	st.Uid = uint32(uvarint())
+	st.Gid = uint32(uvarint())
+	setStatField(&st.Mode, uvarint())
+	setStatField(&st.Ino, uvarint())
+	setStatField(&st.Dev, uvarint())
+	setStatField(&st.Nlink, uvarint())
+	atime := time.Unix(0, varint())
+	mtime := time.Unix(0, varint())
+	ctime := time.Unix(0, varint())
+	if err != nil {
+		return nil, err
+	}
+	util.SetStatsTime(st, atime, ctime, mtime)
+	return e, nil
+}
+
+// Close ...
+func (r *IndexReader) Close() error {
+	return r.file.Close()
+}
+
+// setStatField ... the integer types of Stat_t differ between OS and arch
+func setStatField(field interface{}, v uint64) {
+	f := reflect.ValueOf(field).Elem()
+	switch f.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		f.SetInt(int64(v))
+	default:
+		f.SetUint(v)
+	}
+}
+
+func putUvarint(buf *bytes.Buffer, v uint64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+}
+
+func putVarint(buf *bytes.Buffer, v int64) {
+	var tmp [binary.MaxVarintLen64]byte
+	buf.Write(tmp[:binary.PutVarint(tmp[:], v)])
+}
+
+func putString(buf *bytes.Buffer, s string) {
+	putUvarint(buf, uint64(len(s)))
+	buf.WriteString(s)
+}
+
+func getString(br *bufio.Reader) (string, error) {
+	n, err := binary.ReadUvarint(br)
+	if err != nil {
+		return "", err
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func min64(a, b uint64) uint64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// RunIndex ... same as RunProfile, but reads the directories from the
+// index in wc.FromIndex instead of walking the file system. Only the
+// directories under ws.RootPath, the index root if not set, are counted.
+func RunIndex(wc *WalkControl, ws *WalkStat) error {
+	r, err := OpenIndex(wc.FromIndex)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	if ws.RootPath == "" {
+		ws.RootPath = r.Root
+	}
+	// the directories a stopped scan didn't get to are missing
+	ws.Partial = r.Partial
+	root := filepath.Clean(ws.RootPath)
+	initWalkStat(wc, ws)
+
+	tick := time.Tick(500 * time.Millisecond)
+
+	// a directory read in batches comes in several records, it is
+	// ranked once they are all read
+	type dirSum struct{ entries, size int64 }
+	var sums map[string]*dirSum
+	if wc.TopNdirs || wc.Findc != nil && wc.Findc.DirSizes() {
+		sums = make(map[string]*dirSum)
+	}
+
+	for {
+		dir, entries, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if stopping(wc) {
+			ws.Partial = true
+			break
+		}
+		if !indexReachable(wc, root, dir) {
+			continue
+		}
+
+		res := ScanResult{dirPath: dir, batched: true}
+		scanEntries(wc, ws, &res, entries)
+		mergeResult(wc, ws, &res)
+		if sums != nil {
+			if sums[dir] == nil {
+				sums[dir] = &dirSum{}
+			}
+			sums[dir].entries += int64(len(entries))
+			sums[dir].size += res.fileSizeAgg
+		}
+
+		select {
+		case <-tick:
+			if wc.DoProgress {
+				WalkProgressReport(ws)
+			}
+		default:
+		}
+	}
+	dirs := make([]string, 0, len(sums))
+	for dir := range sums {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		endOfDir(wc, ws, dir, sums[dir].entries, sums[dir].size)
+	}
+	if wc.DoTree {
+		ws.Tree.Rollup()
+	}
+	return nil
+}
+
+// indexReachable ... true if a walk from root would get to dir
+func indexReachable(wc *WalkControl, root string, dir string) bool {
+	if dir != root && !isUnder(root, dir) {
+		return false
+	}
+	for d := dir; d != root && d != "/" && d != "."; d = filepath.Dir(d) {
+		if wc.Exclude.Excluded(d, true) {
+			return false
+		}
+	}
+	return true
+}
+
+// IndexPrologue ... WalkPrologue for a walk read from an index
+func IndexPrologue(wc *WalkControl) {
+	fmt.Printf("\nIndex: %s \n\n", wc.FromIndex)
+}
@@ -0,0 +1,175 @@
+package fs
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func buildIndex(t *testing.T, root string, file string) *WalkStat {
+	w, err := CreateIndex(file, root, 2, false)
+	assert.Nil(t, err)
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{DoByUser: true, Index: w}, ws)
+	assert.Nil(t, w.Close())
+	return ws
+}
+
+func TestIndexRoundTrip(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	file := root + ".pidx"
+	defer os.Remove(file)
+	buildIndex(t, root, file)
+
+	r, err := OpenIndex(file)
+	assert.Nil(t, err)
+	defer r.Close()
+	assert.Equal(t, root, r.Root)
+	assert.Equal(t, IndexVersion, r.Version)
+
+	dirs := map[string]int{}
+	for {
+		dir, entries, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		dirs[dir] = len(entries)
+		for _, e := range entries {
+			fi, err := os.Lstat(filepath.Join(dir, e.Name()))
+			assert.Nil(t, err)
+			assert.Equal(t, fi.Mode(), e.Mode())
+			assert.Equal(t, fi.Size(), e.Size())
+			assert.Equal(t, fi.ModTime(), e.ModTime())
+			st, est := fi.Sys().(*syscall.Stat_t), e.Sys().(*syscall.Stat_t)
+			assert.Equal(t, st.Ino, est.Ino)
+			assert.Equal(t, st.Blocks, est.Blocks)
+			assert.Equal(t, st.Uid, est.Uid)
+		}
+	}
+	assert.Equal(t, map[string]int{
+		root:                       3,
+		filepath.Join(root, "a"):   2,
+		filepath.Join(root, "b"):   1,
+		filepath.Join(root, "b/c"): 1,
+	}, dirs)
+}
+
+func TestRunIndex(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	file := root + ".pidx"
+	defer os.Remove(file)
+	full := buildIndex(t, root, file)
+
+	ws := &WalkStat{}
+	assert.Nil(t, RunIndex(&WalkControl{DoByUser: true, FromIndex: file}, ws))
+	assert.Equal(t, root, ws.RootPath)
+	assert.Equal(t, full.TotFileCnt, ws.TotFileCnt)
+	assert.Equal(t, full.TotDirCnt, ws.TotDirCnt)
+	assert.Equal(t, full.TotFileSize, ws.TotFileSize)
+	assert.Equal(t, UserUsage(full), UserUsage(ws))
+
+	// a subtree, and an exclusion, of the indexed tree
+	ws = &WalkStat{RootPath: filepath.Join(root, "b")}
+	assert.Nil(t, RunIndex(&WalkControl{FromIndex: file}, ws))
+	assert.Equal(t, int64(1), ws.TotFileCnt)
+	assert.Equal(t, int64(4000), ws.TotFileSize)
+
+	ws = &WalkStat{RootPath: root}
+	wc := &WalkControl{FromIndex: file, Exclude: NewExcluder(root)}
+	wc.Exclude.AddPath(filepath.Join(root, "a"))
+	assert.Nil(t, RunIndex(wc, ws))
+	assert.Equal(t, int64(2), ws.TotFileCnt)
+}
+
+func TestIndexAppend(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	file := root + ".pidx"
+	defer os.Remove(file)
+	full := buildIndex(t, root, file)
+	first, err := OpenIndex(file)
+	assert.Nil(t, err)
+	first.Close()
+
+	rescan := func() *WalkStat {
+		w, err := CreateIndex(file, root, 2, true)
+		assert.Nil(t, err)
+		ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+		RunProfile(&WalkControl{Index: w}, ws)
+		assert.Nil(t, w.Close())
+		return ws
+	}
+
+	// the same tree again, counted once
+	rescan()
+	ws := &WalkStat{}
+	assert.Nil(t, RunIndex(&WalkControl{FromIndex: file}, ws))
+	assert.Equal(t, full.TotFileCnt, ws.TotFileCnt)
+	assert.Equal(t, full.TotDirCnt, ws.TotDirCnt)
+	assert.Equal(t, full.TotFileSize, ws.TotFileSize)
+
+	r, err := OpenIndex(file)
+	assert.Nil(t, err)
+	assert.True(t, r.Created.After(first.Created))
+	r.Close()
+
+	// and diffs as unchanged against the first scan alone
+	single := root + ".single.pidx"
+	defer os.Remove(single)
+	buildIndex(t, root, single)
+	ds, err := DiffIndex(&DiffControl{List: true}, single, file)
+	assert.Nil(t, err)
+	assert.Empty(t, ds.Changes)
+
+	// a directory removed in between is gone from the index
+	assert.Nil(t, os.RemoveAll(filepath.Join(root, "b")))
+	now := rescan()
+	ws = &WalkStat{}
+	assert.Nil(t, RunIndex(&WalkControl{FromIndex: file}, ws))
+	assert.Equal(t, now.TotFileCnt, ws.TotFileCnt)
+	assert.Equal(t, now.TotDirCnt, ws.TotDirCnt)
+	assert.Equal(t, now.TotFileSize, ws.TotFileSize)
+
+	// another root can't be appended
+	_, err = CreateIndex(file, filepath.Join(root, "a"), 1, true)
+	assert.NotNil(t, err)
+}
+
+func TestIndexPartial(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	full := root + ".pidx"
+	defer os.Remove(full)
+	buildIndex(t, root, full)
+	r, err := OpenIndex(full)
+	assert.Nil(t, err)
+	assert.False(t, r.Partial)
+	r.Close()
+
+	// stopped before the first directory
+	file := root + ".partial.pidx"
+	defer os.Remove(file)
+	w, err := CreateIndex(file, root, 2, false)
+	assert.Nil(t, err)
+	stop := make(chan struct{})
+	close(stop)
+	RunProfile(&WalkControl{Index: w, Stop: stop}, &WalkStat{RootPath: root, NumOfWorkers: 2})
+	assert.Nil(t, w.Close())
+
+	r, err = OpenIndex(file)
+	assert.Nil(t, err)
+	assert.True(t, r.Partial)
+	r.Close()
+	ws := &WalkStat{}
+	assert.Nil(t, RunIndex(&WalkControl{FromIndex: file}, ws))
+	assert.True(t, ws.Partial)
+	_, err = DiffIndex(&DiffControl{}, full, file)
+	assert.NotNil(t, err)
+}
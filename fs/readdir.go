@@ -0,0 +1,147 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// DefaultBatchSize ... entries of a directory scanned by one job, a
+// larger directory is read and scanned a batch at a time
+const DefaultBatchSize = 10000
+
+// readDir ... the entries of dir sorted by name, as ioutil.ReadDir
+func readDir(dir string) ([]os.FileInfo, error) {
+	s, err := openDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer s.close()
+	return s.next(0)
+}
+
+// next ... the next batch of n entries sorted by name, fewer at the end
+// of the directory, all of them if n <= 0
+func (s *dirStream) next(n int) ([]os.FileInfo, error) {
+	files, err := s.read(n)
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Name() < files[j].Name() })
+	s.count += int64(len(files))
+	return files, nil
+}
+
+// dirEntry ... an entry as read from its directory by readDir. The
+// type comes with the name, the entry is lstat'ed the first time
+// anything else is asked for, as a Size or a time predicate would.
+// It is not safe for concurrent use.
+type dirEntry struct {
+	dir  string
+	name string
+	typ  os.FileMode // the os.ModeType bits only
+	mask int         // the statx(2) fields to ask for, all if 0
+	fi   os.FileInfo // once lstat'ed
+	err  error       // if the lstat failed
+}
+
+func (e *dirEntry) Name() string       { return e.name }
+func (e *dirEntry) Size() int64        { return e.stat().Size() }
+func (e *dirEntry) Mode() os.FileMode  { return e.stat().Mode() }
+func (e *dirEntry) ModTime() time.Time { return e.stat().ModTime() }
+func (e *dirEntry) IsDir() bool        { return e.typ.IsDir() }
+func (e *dirEntry) Sys() interface{}   { return e.stat().Sys() }
+
+func (e *dirEntry) BirthTime() (time.Time, bool) {
+	return fileBirth(e.stat())
+}
+
+func (e *dirEntry) stat() os.FileInfo {
+	if e.fi == nil {
+		fname := filepath.Join(e.dir, e.name)
+		fi, err := lstat(fname, e.mask)
+		if err != nil {
+			log.Debugf("Can't lstat %s: %v", fname, err)
+			fi, e.err = &goneInfo{e.name, e.typ}, err
+		}
+		e.fi = fi
+	}
+	return e.fi
+}
+
+// needsStat ... true if a scan asks an entry of type typ for more than
+// its name and type
+func needsStat(wc *WalkControl, typ os.FileMode) bool {
+	const meta = FB_SIZE | FB_ATIME | FB_CTIME | FB_MTIME | FB_BTIME |
+		FB_USER | FB_GROUP | FB_NOUSER | FB_PERM
+	switch {
+	case wc.Index != nil:
+		return true
+	case wc.Findc != nil && Has(wc.Findc.Flags, meta):
+		return true
+	case typ.IsDir():
+		return wc.XDev || wc.Follow
+	case typ.IsRegular():
+		return !wc.NoTally
+	}
+	return false
+}
+
+// statEntries ... lstat the entries of files the scan needs, before any
+// predicate or counter sees them. As ioutil.ReadDir, an entry removed
+// since the directory was read is left out, and one that can't be
+// lstat'ed otherwise is left out and counted as skipped.
+func statEntries(wc *WalkControl, res *ScanResult, files []os.FileInfo) []os.FileInfo {
+	kept := files[:0]
+	for _, fi := range files {
+		if e, ok := fi.(*dirEntry); ok {
+			if e.err == nil && needsStat(wc, e.typ) {
+				e.stat()
+			}
+			if e.err != nil {
+				if !os.IsNotExist(e.err) {
+					if wc.Verbose {
+						log.Println(e.err)
+					}
+					res.skipCnt++
+				}
+				continue
+			}
+		}
+		kept = append(kept, fi)
+	}
+	return kept
+}
+
+// goneInfo ... stands for an entry that can't be lstat'ed, with a zero
+// size and times. statEntries keeps it from the scan.
+type goneInfo struct {
+	name string
+	typ  os.FileMode
+}
+
+func (g *goneInfo) Name() string       { return g.name }
+func (g *goneInfo) Size() int64        { return 0 }
+func (g *goneInfo) Mode() os.FileMode  { return g.typ }
+func (g *goneInfo) ModTime() time.Time { return time.Time{} }
+func (g *goneInfo) IsDir() bool        { return g.typ.IsDir() }
+func (g *goneInfo) Sys() interface{}   { return &syscall.Stat_t{} }
+
+// fileBirth ... the birth time of fi, if the file system keeps it
+func fileBirth(fi os.FileInfo) (time.Time, bool) {
+	if b, ok := fi.(interface{ BirthTime() (time.Time, bool) }); ok {
+		return b.BirthTime()
+	}
+	return sysBirth(fi.Sys().(*syscall.Stat_t))
+}
+
+// fileType ... the os.ModeType bits of the mode of fi, a dirEntry
+// knows them without an lstat
+func fileType(fi os.FileInfo) os.FileMode {
+	if e, ok := fi.(*dirEntry); ok {
+		return e.typ
+	}
+	return fi.Mode() & os.ModeType
+}
@@ -0,0 +1,120 @@
+package fs
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"unsafe"
+)
+
+const direntBufSize = 32 * 1024
+
+// direntTypes ... d_type to the os.ModeType bits, DT_UNKNOWN is not
+// there and takes an lstat
+var direntTypes = map[uint8]os.FileMode{
+	syscall.DT_REG:  0,
+	syscall.DT_DIR:  os.ModeDir,
+	syscall.DT_LNK:  os.ModeSymlink,
+	syscall.DT_FIFO: os.ModeNamedPipe,
+	syscall.DT_SOCK: os.ModeSocket,
+	syscall.DT_CHR:  os.ModeDevice | os.ModeCharDevice,
+	syscall.DT_BLK:  os.ModeDevice,
+}
+
+// dirStream ... a directory read with getdents64, its entries are not
+// lstat'ed until needed. Only the entries of a file system that doesn't
+// fill in d_type are lstat'ed right away.
+type dirStream struct {
+	dir   string
+	mask  int // the statx(2) fields the entries ask for
+	fd    int
+	buf   []byte
+	left  []os.FileInfo // read, not returned yet
+	end   bool          // getdents64 has no more
+	eof   bool          // and nothing is left
+	count int64         // entries returned so far
+}
+
+func openDir(dir string) (*dirStream, error) {
+	fd, err := syscall.Open(dir, syscall.O_RDONLY|syscall.O_DIRECTORY|syscall.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+	// the slack past what getdents64 fills keeps a whole Dirent in the
+	// buffer, however close to its end a record starts
+	buf := make([]byte, direntBufSize+int(unsafe.Sizeof(syscall.Dirent{})))
+	return &dirStream{dir: dir, fd: fd, buf: buf}, nil
+}
+
+// read ... the next n entries, fewer at the end, all of them if n <= 0
+func (s *dirStream) read(n int) ([]os.FileInfo, error) {
+	for !s.end && (n <= 0 || len(s.left) < n) {
+		m, err := syscall.ReadDirent(s.fd, s.buf[:direntBufSize])
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			return nil, &os.PathError{Op: "getdents64", Path: s.dir, Err: err}
+		}
+		if m <= 0 {
+			s.end = true
+			break
+		}
+		s.left = parseDirents(s.dir, s.mask, s.buf[:m], s.left)
+	}
+	files := s.left
+	if n > 0 && len(files) > n {
+		files, s.left = files[:n:n], files[n:]
+	} else {
+		s.left = nil
+	}
+	s.eof = s.end && len(s.left) == 0
+	return files, nil
+}
+
+func (s *dirStream) close() {
+	syscall.Close(s.fd)
+}
+
+// parseDirents ... append the entries of the linux_dirent64 records in
+// buf to files, but "." and ".."
+func parseDirents(dir string, mask int, buf []byte, files []os.FileInfo) []os.FileInfo {
+	nameOff := int(unsafe.Offsetof(syscall.Dirent{}.Name))
+	for len(buf) > nameOff {
+		d := (*syscall.Dirent)(unsafe.Pointer(&buf[0]))
+		reclen := int(d.Reclen)
+		if reclen <= nameOff || reclen > len(buf) {
+			break
+		}
+		rec := buf[:reclen]
+		buf = buf[reclen:]
+		if d.Ino == 0 {
+			continue // deleted
+		}
+		name := rec[nameOff:]
+		if i := bytes.IndexByte(name, 0); i >= 0 {
+			name = name[:i]
+		}
+		if string(name) == "." || string(name) == ".." {
+			continue
+		}
+
+		e := &dirEntry{dir: dir, name: string(name), mask: mask}
+		if typ, ok := direntTypes[d.Type]; ok {
+			e.typ = typ
+		} else {
+			fi, err := lstat(filepath.Join(dir, e.name), mask)
+			switch {
+			case os.IsNotExist(err):
+				continue // gone already, as ioutil.ReadDir
+			case err != nil:
+				e.err = err // skipped by statEntries
+			default:
+				e.typ, e.fi = fi.Mode()&os.ModeType, fi
+			}
+		}
+		files = append(files, e)
+	}
+	return files
+}
@@ -0,0 +1,37 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fwang2/pi/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWalkBatchFails(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	a := filepath.Join(root, "a")
+	for i := 0; i < 25; i++ {
+		fname := filepath.Join(a, fmt.Sprintf("g%02d", i))
+		assert.Nil(t, ioutil.WriteFile(fname, []byte("x"), 0644))
+	}
+
+	// a read failing after the first batch ends the directory with the
+	// batches read, it is not skipped
+	wc := &WalkControl{BatchSize: 4, TopNdirs: true}
+	ws := &WalkStat{RootPath: root, TopNDirQ: util.NewSortedQueue(2)}
+	initWalkStat(wc, ws)
+	res := Walk(wc, ws, a).(ScanResult)
+	assert.NotNil(t, res.stream)
+	res.stream.close()
+	res.stream.left = nil // read again, from the closed fd
+	last, ok := Walk(wc, ws, a, res.stream).(ScanResult)
+	assert.True(t, ok)
+	assert.True(t, last.batched)
+	assert.Equal(t, int64(0), last.entryCnt)
+	assert.Equal(t, util.ItemList{{Name: a, Val: 4}}, ws.TopNDirQ.Items())
+}
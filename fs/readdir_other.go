@@ -0,0 +1,43 @@
+// +build !linux
+
+package fs
+
+import (
+	"io"
+	"os"
+)
+
+// dirStream ... a directory read with os.File.Readdir, its entries are
+// lstat'ed already
+type dirStream struct {
+	dir   string
+	mask  int // not used, there is no statx(2)
+	f     *os.File
+	eof   bool
+	count int64 // entries returned so far
+}
+
+func openDir(dir string) (*dirStream, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, err
+	}
+	return &dirStream{dir: dir, f: f}, nil
+}
+
+// read ... the next n entries, fewer at the end, all of them if n <= 0
+func (s *dirStream) read(n int) ([]os.FileInfo, error) {
+	if n <= 0 {
+		s.eof = true
+		return s.f.Readdir(-1)
+	}
+	files, err := s.f.Readdir(n)
+	if err == io.EOF || len(files) < n {
+		s.eof, err = true, nil
+	}
+	return files, err
+}
+
+func (s *dirStream) close() {
+	s.f.Close()
+}
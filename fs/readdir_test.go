@@ -0,0 +1,158 @@
+package fs
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/fwang2/pi/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadDir(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	assert.Nil(t, os.Symlink("f1", filepath.Join(root, "l1")))
+	assert.Nil(t, syscall.Mkfifo(filepath.Join(root, "p1"), 0644))
+
+	want, err := ioutil.ReadDir(root)
+	assert.Nil(t, err)
+	files, err := readDir(root)
+	assert.Nil(t, err)
+	assert.Equal(t, len(want), len(files))
+	for i, fi := range files {
+		assert.Equal(t, want[i].Name(), fi.Name())
+		assert.Equal(t, want[i].Mode()&os.ModeType, fileType(fi), fi.Name())
+		if e, ok := fi.(*dirEntry); ok {
+			assert.Nil(t, e.fi, "lstat'ed before asked for")
+		}
+		assert.Equal(t, want[i].Mode(), fi.Mode())
+		assert.Equal(t, want[i].Size(), fi.Size())
+		assert.Equal(t, want[i].Sys().(*syscall.Stat_t).Ino, fi.Sys().(*syscall.Stat_t).Ino)
+	}
+
+	_, err = readDir(filepath.Join(root, "f1"))
+	assert.NotNil(t, err)
+}
+
+func TestDirEntryGone(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+
+	files, err := readDir(root)
+	assert.Nil(t, err)
+	assert.Nil(t, os.Remove(filepath.Join(root, "f1")))
+	for _, fi := range files {
+		if fi.Name() == "f1" {
+			assert.True(t, fileType(fi).IsRegular())
+			assert.Equal(t, int64(0), fi.Size())
+			assert.NotNil(t, fi.Sys().(*syscall.Stat_t))
+		}
+	}
+}
+
+func TestStatEntries(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+
+	// f9 is gone, the dir of x is a file: lstat fails with ENOTDIR
+	files := []os.FileInfo{
+		&dirEntry{dir: root, name: "f1"},
+		&dirEntry{dir: root, name: "f9"},
+		&dirEntry{dir: filepath.Join(root, "f1"), name: "x"},
+		&dirEntry{dir: root, name: "a", typ: os.ModeDir},
+	}
+	var res ScanResult
+	files = statEntries(&WalkControl{}, &res, files)
+	assert.Equal(t, 2, len(files))
+	assert.Equal(t, "f1", files[0].Name())
+	assert.Equal(t, "a", files[1].Name())
+	assert.Equal(t, int64(1), res.skipCnt)
+	assert.Nil(t, files[1].(*dirEntry).fi, "lstat'ed without need")
+
+	// nothing is lstat'ed to count names only
+	res = ScanResult{}
+	files = []os.FileInfo{&dirEntry{dir: filepath.Join(root, "f1"), name: "x"}}
+	assert.Equal(t, 1, len(statEntries(&WalkControl{NoTally: true}, &res, files)))
+	assert.Equal(t, int64(0), res.skipCnt)
+}
+
+func TestWalkNoTally(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{NoTally: true}, ws)
+	assert.Equal(t, int64(4), ws.TotFileCnt)
+	assert.Equal(t, int64(3), ws.TotDirCnt)
+	assert.Equal(t, int64(0), ws.TotFileSize)
+}
+
+func TestDirStreamBatches(t *testing.T) {
+	root, err := ioutil.TempDir("", "pi")
+	assert.Nil(t, err)
+	defer os.RemoveAll(root)
+	for i := 0; i < 25; i++ {
+		assert.Nil(t, ioutil.WriteFile(filepath.Join(root, fmt.Sprintf("f%02d", i)), nil, 0644))
+	}
+
+	s, err := openDir(root)
+	assert.Nil(t, err)
+	defer s.close()
+	seen := make(map[string]bool)
+	var sizes []int
+	for !s.eof {
+		files, err := s.next(10)
+		assert.Nil(t, err)
+		sizes = append(sizes, len(files))
+		for _, fi := range files {
+			seen[fi.Name()] = true
+		}
+	}
+	assert.Equal(t, []int{10, 10, 5}, sizes)
+	assert.Equal(t, 25, len(seen))
+	assert.Equal(t, int64(25), s.count)
+}
+
+func TestWalkBatches(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	// root/{f1, a/{f2, f3, g00..g24}, b/c/f4}
+	for i := 0; i < 25; i++ {
+		fname := filepath.Join(root, "a", fmt.Sprintf("g%02d", i))
+		assert.Nil(t, ioutil.WriteFile(fname, []byte("x"), 0644))
+	}
+	walk := func(batch int, index string) *WalkStat {
+		ws := &WalkStat{RootPath: root, NumOfWorkers: 3, TopNDirQ: util.NewSortedQueue(2)}
+		wc := &WalkControl{BatchSize: batch, DoTree: true, TopNdirs: true}
+		if index != "" {
+			w, err := CreateIndex(index, root, 2, false)
+			assert.Nil(t, err)
+			wc.Index = w
+			defer func() { assert.Nil(t, w.Close()) }()
+		}
+		RunProfile(wc, ws)
+		return ws
+	}
+
+	file := root + ".pidx"
+	defer os.Remove(file)
+	want := walk(0, "")
+	for _, ws := range []*WalkStat{walk(4, ""), walk(4, file)} {
+		assert.Equal(t, int64(29), ws.TotFileCnt)
+		assert.Equal(t, want.TotFileSize, ws.TotFileSize)
+		assert.Equal(t, want.TotDirCnt, ws.TotDirCnt)
+		assert.Equal(t, want.TopNDirQ.Items(), ws.TopNDirQ.Items())
+		assert.Equal(t, want.Tree.Dir(filepath.Join(root, "a")), ws.Tree.Dir(filepath.Join(root, "a")))
+	}
+	assert.Equal(t, util.Item{Name: filepath.Join(root, "a"), Val: 27}, want.TopNDirQ.Items()[1])
+
+	// the index has several records of a, ranked once
+	ws := &WalkStat{NumOfWorkers: 1, TopNDirQ: util.NewSortedQueue(2)}
+	assert.Nil(t, RunIndex(&WalkControl{FromIndex: file, TopNdirs: true}, ws))
+	assert.Equal(t, want.TopNDirQ.Items(), ws.TopNDirQ.Items())
+	assert.Equal(t, int64(29), ws.TotFileCnt)
+}
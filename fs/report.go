@@ -0,0 +1,301 @@
+package fs
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/fwang2/pi/util"
+)
+
+// ReportSchema ... identifies the document produced by `pi profile --format`
+const ReportSchema = "pi.profile"
+
+// ReportVersion ... bump whenever a field is renamed or removed.
+// Adding new fields does not require a bump.
+const ReportVersion = 1
+
+// FSInfo ... exported view of InfoT
+type FSInfo struct {
+	Summary     string `json:"summary"`
+	TotalBytes  int64  `json:"total_bytes"`
+	FreeBytes   int64  `json:"free_bytes"`
+	TotalInodes int64  `json:"total_inodes"`
+	FreeInodes  int64  `json:"free_inodes"`
+}
+
+// Totals ... global counters of a walk
+type Totals struct {
+	Files       int64 `json:"files"`
+	Dirs        int64 `json:"dirs"`
+	Symlinks    int64 `json:"symlinks"`
+	Pipes       int64 `json:"pipes"`
+	Sparse      int64 `json:"sparse"`
+	Skipped     int64 `json:"skipped"`
+	Excluded    int64 `json:"excluded"`
+	FileBytes   int64 `json:"file_bytes"`
+	AvgFileSize int64 `json:"avg_file_size"`
+
+	// extra hard links to files already counted, not in Files and FileBytes
+	Hardlinks     int64 `json:"hardlinks"`
+	HardlinkBytes int64 `json:"hardlink_bytes"`
+}
+
+// HistBin ... one bucket of the size histogram.
+// UpperBound is inclusive, the last bucket is open ended.
+type HistBin struct {
+	UpperBound int64  `json:"upper_bound"`
+	Label      string `json:"label"`
+	Count      int64  `json:"count"`
+}
+
+// AgeBin ... one bucket of the file age distribution. MaxAgeSeconds
+// is inclusive, the last bucket is open ended and has none.
+type AgeBin struct {
+	MaxAgeSeconds int64  `json:"max_age_seconds,omitempty"`
+	Label         string `json:"label"`
+	Files         int64  `json:"files"`
+	Bytes         int64  `json:"bytes"`
+}
+
+// TopItem ... an entry of a top-N list
+type TopItem struct {
+	Path  string     `json:"path"`
+	Bytes int64      `json:"bytes"`
+	Born  *time.Time `json:"born,omitempty"` // if the file system keeps it
+}
+
+// Report ... stable, versioned view of a WalkStat
+type Report struct {
+	Schema         string       `json:"schema"`
+	Version        int          `json:"version"`
+	Root           string       `json:"root"`
+	Workers        int          `json:"workers"`
+	Partial        bool         `json:"partial"`
+	Generated      time.Time    `json:"generated"`
+	FS             FSInfo       `json:"fs"`
+	Totals         Totals       `json:"totals"`
+	Histogram      []HistBin    `json:"histogram,omitempty"`
+	Users          []OwnerUsage `json:"users,omitempty"`
+	Groups         []OwnerUsage `json:"groups,omitempty"`
+	Extensions     []ExtUsage   `json:"extensions,omitempty"`
+	AgeField       string       `json:"age_field,omitempty"`
+	AgeHistogram   []AgeBin     `json:"age_histogram,omitempty"`
+	TopFiles       []TopItem    `json:"top_files,omitempty"`
+	TopDirs        []TopItem    `json:"top_dirs,omitempty"`
+	SkippedMounts  []string     `json:"skipped_mounts,omitempty"`
+	BrokenLinks    []string     `json:"broken_links,omitempty"`
+	LoopLinks      []string     `json:"loop_links,omitempty"`
+	OutsideLinks   []string     `json:"outside_links,omitempty"`
+	Rate           int64        `json:"rate"`
+	ElapsedSeconds float64      `json:"elapsed_seconds"`
+}
+
+// NewReport ... build a report from the stats of a finished walk
+func NewReport(wc *WalkControl, ws *WalkStat) *Report {
+	r := &Report{
+		Schema:         ReportSchema,
+		Version:        ReportVersion,
+		Root:           ws.RootPath,
+		Workers:        ws.NumOfWorkers,
+		Partial:        ws.Partial,
+		Generated:      time.Now(),
+		Rate:           ws.Rate,
+		ElapsedSeconds: ws.Elapsed.Seconds(),
+	}
+
+	if wc.FromIndex != "" {
+		// the file system may be gone, or elsewhere
+		r.FS = FSInfo{Summary: "index " + wc.FromIndex}
+	} else {
+		fsinfo := StatInfo(ws.RootPath)
+		r.FS = FSInfo{
+			Summary:     InfoStr(ws.RootPath),
+			TotalBytes:  fsinfo.totFileSystemSize,
+			FreeBytes:   fsinfo.freeFileSystemSize,
+			TotalInodes: fsinfo.totInodes,
+			FreeInodes:  fsinfo.freeInodes,
+		}
+	}
+
+	r.Totals = Totals{
+		Files:     ws.TotFileCnt,
+		Dirs:      ws.TotDirCnt,
+		Symlinks:  ws.TotSymlinkCnt,
+		Pipes:     ws.TotPipeCnt,
+		Sparse:    ws.TotSparseCnt,
+		Skipped:   ws.TotSkipped,
+		Excluded:  ws.TotExcluded,
+		FileBytes: ws.TotFileSize,
+
+		Hardlinks:     ws.TotLinkCnt,
+		HardlinkBytes: ws.TotLinkSize,
+	}
+	if ws.TotFileCnt != 0 {
+		r.Totals.AvgFileSize = ws.TotFileSize / ws.TotFileCnt
+	}
+
+	if wc.DoHist {
+		r.Histogram = make([]HistBin, 0, len(ws.HistBins))
+		for k, v := range ws.HistBins {
+			var label string
+			if v == util.GUARD {
+				label = "> " + util.ShortByte(ws.HistBins[len(ws.HistBins)-2])
+			} else {
+				label = "<= " + util.ShortByte(v)
+			}
+			r.Histogram = append(r.Histogram,
+				HistBin{UpperBound: v, Label: label, Count: ws.HistCounter[k]})
+		}
+	}
+	if wc.DoByUser {
+		r.Users = UserUsage(ws)
+	}
+	if wc.DoByGroup {
+		r.Groups = GroupUsage(ws)
+	}
+	if wc.DoByExt {
+		r.Extensions = TopExtUsage(ws, wc.TopExts)
+	}
+	r.SkippedMounts = ws.Mounts
+	r.BrokenLinks = ws.BrokenLinks
+	r.LoopLinks = ws.LoopLinks
+	r.OutsideLinks = ws.OutsideLinks
+	if wc.DoAgeHist {
+		r.AgeField = AgeFieldName(wc.AgeField)
+		for i := range ws.AgeFiles {
+			b := AgeBin{Label: AgeLabel(ws.AgeBins, i),
+				Files: ws.AgeFiles[i], Bytes: ws.AgeBytes[i]}
+			if i < len(ws.AgeBins) {
+				b.MaxAgeSeconds = int64(ws.AgeBins[i].Seconds())
+			}
+			r.AgeHistogram = append(r.AgeHistogram, b)
+		}
+	}
+	// the top lists are as long as TopNFileQ
+	if wc.TopNfiles {
+		items := ws.TopNFileQ.Items()
+		for i := len(items) - 1; i >= 0; i-- {
+			it := TopItem{Path: items[i].Name, Bytes: items[i].Val}
+			if items[i].Time != 0 {
+				born := time.Unix(0, items[i].Time)
+				it.Born = &born
+			}
+			r.TopFiles = append(r.TopFiles, it)
+		}
+		if wc.DoTree {
+			for _, d := range ws.Tree.Top(ws.TopNFileQ.Cap(), false) {
+				r.TopDirs = append(r.TopDirs, TopItem{Path: d.Path, Bytes: d.Bytes})
+			}
+		}
+	}
+	return r
+}
+
+// AgeFieldName ... atime, ctime or mtime, as picked by flag
+func AgeFieldName(flag Bits) string {
+	switch {
+	case Has(flag, FB_ATIME):
+		return "atime"
+	case Has(flag, FB_CTIME):
+		return "ctime"
+	}
+	return "mtime"
+}
+
+// WriteJSON ... write the report as a single indented JSON document
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	return enc.Encode(r)
+}
+
+// WriteCSV ... write the report as "section,key,value" rows
+// so it can be loaded without knowing the nesting of the JSON form.
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	i64 := func(v int64) string { return strconv.FormatInt(v, 10) }
+
+	rows := [][]string{
+		{"section", "key", "value"},
+		{"meta", "schema", r.Schema},
+		{"meta", "version", strconv.Itoa(r.Version)},
+		{"meta", "root", r.Root},
+		{"meta", "workers", strconv.Itoa(r.Workers)},
+		{"meta", "partial", strconv.FormatBool(r.Partial)},
+		{"meta", "rate", i64(r.Rate)},
+		{"meta", "elapsed_seconds", strconv.FormatFloat(r.ElapsedSeconds, 'f', 3, 64)},
+		{"fs", "summary", r.FS.Summary},
+		{"fs", "total_bytes", i64(r.FS.TotalBytes)},
+		{"fs", "free_bytes", i64(r.FS.FreeBytes)},
+		{"fs", "total_inodes", i64(r.FS.TotalInodes)},
+		{"fs", "free_inodes", i64(r.FS.FreeInodes)},
+		{"totals", "files", i64(r.Totals.Files)},
+		{"totals", "dirs", i64(r.Totals.Dirs)},
+		{"totals", "symlinks", i64(r.Totals.Symlinks)},
+		{"totals", "pipes", i64(r.Totals.Pipes)},
+		{"totals", "sparse", i64(r.Totals.Sparse)},
+		{"totals", "skipped", i64(r.Totals.Skipped)},
+		{"totals", "excluded", i64(r.Totals.Excluded)},
+		{"totals", "file_bytes", i64(r.Totals.FileBytes)},
+		{"totals", "avg_file_size", i64(r.Totals.AvgFileSize)},
+		{"totals", "hardlinks", i64(r.Totals.Hardlinks)},
+		{"totals", "hardlink_bytes", i64(r.Totals.HardlinkBytes)},
+	}
+	for _, b := range r.Histogram {
+		rows = append(rows, []string{"histogram", i64(b.UpperBound), i64(b.Count)})
+	}
+	for _, u := range r.Users {
+		rows = append(rows,
+			[]string{"user_files", u.Name, i64(u.Files)},
+			[]string{"user_bytes", u.Name, i64(u.Bytes)},
+			[]string{"user_allocated", u.Name, i64(u.Allocated)})
+	}
+	for _, g := range r.Groups {
+		rows = append(rows,
+			[]string{"group_files", g.Name, i64(g.Files)},
+			[]string{"group_bytes", g.Name, i64(g.Bytes)},
+			[]string{"group_allocated", g.Name, i64(g.Allocated)})
+	}
+
+	for _, e := range r.Extensions {
+		rows = append(rows,
+			[]string{"ext_files", e.Ext, i64(e.Files)},
+			[]string{"ext_bytes", e.Ext, i64(e.Bytes)},
+			[]string{"ext_allocated", e.Ext, i64(e.Allocated)})
+	}
+	if r.AgeField != "" {
+		rows = append(rows, []string{"meta", "age_field", r.AgeField})
+	}
+	for _, b := range r.AgeHistogram {
+		rows = append(rows,
+			[]string{"age_files", b.Label, i64(b.Files)},
+			[]string{"age_bytes", b.Label, i64(b.Bytes)})
+	}
+	for _, f := range r.TopFiles {
+		rows = append(rows, []string{"top_file", f.Path, i64(f.Bytes)})
+	}
+	for _, d := range r.TopDirs {
+		rows = append(rows, []string{"top_dir", d.Path, i64(d.Bytes)})
+	}
+	for _, m := range r.SkippedMounts {
+		rows = append(rows, []string{"skipped_mount", m, ""})
+	}
+	for _, l := range r.BrokenLinks {
+		rows = append(rows, []string{"broken_link", l, ""})
+	}
+	for _, l := range r.LoopLinks {
+		rows = append(rows, []string{"loop_link", l, ""})
+	}
+	for _, l := range r.OutsideLinks {
+		rows = append(rows, []string{"outside_link", l, ""})
+	}
+
+	if err := cw.WriteAll(rows); err != nil {
+		return err
+	}
+	return cw.Error()
+}
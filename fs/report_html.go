@@ -0,0 +1,231 @@
+package fs
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"strings"
+
+	"github.com/fwang2/pi/util"
+)
+
+// chartBar ... one bar of an inline SVG chart
+type chartBar struct {
+	Label string
+	Value int64
+	Text  string // shown by the bar
+}
+
+const (
+	chartWidth  = 720
+	chartHeight = 240
+	rowHeight   = 22
+	labelWidth  = 280
+	chartColor  = "#4e79a7"
+)
+
+// svgColumns ... vertical bars, for distributions
+func svgColumns(bars []chartBar) template.HTML {
+	var b strings.Builder
+	max := maxValue(bars)
+	slot := float64(chartWidth) / float64(len(bars))
+	plot := float64(chartHeight - 70)
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-size="11">`,
+		chartWidth, chartHeight)
+	for i, bar := range bars {
+		h := plot * float64(bar.Value) / float64(max)
+		x := slot * float64(i)
+		y := 15 + plot - h
+		fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %s</title></rect>`,
+			x+slot*0.1, y, slot*0.8, h, chartColor, esc(bar.Label), esc(bar.Text))
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="middle">%s</text>`,
+			x+slot/2, y-3, esc(bar.Text))
+		// slanted, so that narrow columns keep readable labels
+		lx, ly := x+slot/2, 15+plot+14
+		fmt.Fprintf(&b, `<text x="%.1f" y="%.1f" text-anchor="end" transform="rotate(-35 %.1f %.1f)">%s</text>`,
+			lx, ly, lx, ly, esc(bar.Label))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// svgRows ... horizontal bars, for ranked lists
+func svgRows(bars []chartBar) template.HTML {
+	var b strings.Builder
+	max := maxValue(bars)
+	plot := float64(chartWidth - labelWidth - 100)
+
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" font-size="11">`,
+		chartWidth, rowHeight*len(bars)+4)
+	for i, bar := range bars {
+		y := rowHeight * i
+		w := plot * float64(bar.Value) / float64(max)
+		label := bar.Label
+		if r := []rune(label); len(r) > 45 {
+			label = "..." + string(r[len(r)-42:])
+		}
+		fmt.Fprintf(&b, `<text x="%d" y="%d" text-anchor="end">%s<title>%s</title></text>`,
+			labelWidth-6, y+15, esc(label), esc(bar.Label))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="%s"/>`,
+			labelWidth, y+3, w, rowHeight-6, chartColor)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d">%s</text>`,
+			float64(labelWidth)+w+6, y+15, esc(bar.Text))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+func maxValue(bars []chartBar) int64 {
+	var max int64 = 1
+	for _, bar := range bars {
+		if bar.Value > max {
+			max = bar.Value
+		}
+	}
+	return max
+}
+
+func esc(s string) string {
+	return template.HTMLEscapeString(s)
+}
+
+// htmlSection ... a titled chart of the report
+type htmlSection struct {
+	Title string
+	Note  string
+	Chart template.HTML
+}
+
+// WriteHTML ... write the report as a single HTML page, charts are
+// inline SVG so the file has no external assets
+func (r *Report) WriteHTML(w io.Writer) error {
+	var sections []htmlSection
+
+	types := []chartBar{
+		{"files", r.Totals.Files, util.Comma(r.Totals.Files)},
+		{"directories", r.Totals.Dirs, util.Comma(r.Totals.Dirs)},
+		{"symlinks", r.Totals.Symlinks, util.Comma(r.Totals.Symlinks)},
+		{"pipes", r.Totals.Pipes, util.Comma(r.Totals.Pipes)},
+	}
+	if r.Totals.Sparse != 0 {
+		types = append(types, chartBar{"sparse files", r.Totals.Sparse, util.Comma(r.Totals.Sparse)})
+	}
+	sections = append(sections, htmlSection{Title: "Entries by type", Chart: svgRows(types)})
+
+	if len(r.Histogram) != 0 {
+		var bars []chartBar
+		for _, b := range r.Histogram {
+			bars = append(bars, chartBar{b.Label, b.Count, util.ShortNum(b.Count)})
+		}
+		sections = append(sections, htmlSection{Title: "File size distribution",
+			Note: "Number of files by size", Chart: svgColumns(bars)})
+	}
+
+	if len(r.AgeHistogram) != 0 {
+		var files, bytes []chartBar
+		for _, b := range r.AgeHistogram {
+			files = append(files, chartBar{b.Label, b.Files, util.ShortNum(b.Files)})
+			bytes = append(bytes, chartBar{b.Label, b.Bytes, util.ShortByte(b.Bytes)})
+		}
+		sections = append(sections,
+			htmlSection{Title: "File age distribution",
+				Note: "Number of files by " + r.AgeField + " age", Chart: svgColumns(files)},
+			htmlSection{Note: "Bytes by " + r.AgeField + " age", Chart: svgColumns(bytes)})
+	}
+
+	if len(r.TopFiles) != 0 {
+		sections = append(sections, htmlSection{Title: "Largest files", Chart: svgRows(topBars(r.TopFiles))})
+	}
+	if len(r.TopDirs) != 0 {
+		sections = append(sections, htmlSection{Title: "Largest directories",
+			Note: "Bytes of the files beneath each directory", Chart: svgRows(topBars(r.TopDirs))})
+	}
+
+	for _, u := range []struct {
+		title string
+		list  []OwnerUsage
+	}{{"Usage by user", r.Users}, {"Usage by group", r.Groups}} {
+		if len(u.list) == 0 {
+			continue
+		}
+		var bars []chartBar
+		for _, o := range u.list {
+			bars = append(bars, chartBar{o.Name, o.Allocated,
+				fmt.Sprintf("%s in %s files", util.ShortByte(o.Allocated), util.Comma(o.Files))})
+		}
+		sections = append(sections, htmlSection{Title: u.title, Note: "Allocated bytes", Chart: svgRows(bars)})
+	}
+
+	if len(r.Extensions) != 0 {
+		var bars []chartBar
+		for _, e := range r.Extensions {
+			bars = append(bars, chartBar{e.Ext, e.Allocated,
+				fmt.Sprintf("%s in %s files", util.ShortByte(e.Allocated), util.Comma(e.Files))})
+		}
+		sections = append(sections, htmlSection{Title: "Usage by file type", Note: "Allocated bytes by extension", Chart: svgRows(bars)})
+	}
+
+	summary := [][2]string{
+		{"Files", util.Comma(r.Totals.Files)},
+		{"Directories", util.Comma(r.Totals.Dirs)},
+		{"Aggregated file size", util.ShortByte(r.Totals.FileBytes)},
+		{"Average file size", util.ShortByte(r.Totals.AvgFileSize)},
+	}
+	if r.Totals.Hardlinks != 0 {
+		summary = append(summary, [2]string{"Extra links, not counted",
+			fmt.Sprintf("%s (%s)", util.Comma(r.Totals.Hardlinks), util.ShortByte(r.Totals.HardlinkBytes))})
+	}
+	summary = append(summary, [][2]string{
+		{"Skipped", util.Comma(r.Totals.Skipped)},
+		{"Scanning rate", fmt.Sprintf("%d/s", r.Rate)},
+		{"Elapsed time", fmt.Sprintf("%.1fs", r.ElapsedSeconds)},
+	}...)
+
+	return htmlTemplate.Execute(w, struct {
+		*Report
+		Sections []htmlSection
+		Summary  [][2]string
+	}{r, sections, summary})
+}
+
+func topBars(items []TopItem) []chartBar {
+	var bars []chartBar
+	for _, it := range items {
+		bars = append(bars, chartBar{it.Path, it.Bytes, util.ShortByte(it.Bytes)})
+	}
+	return bars
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>pi profile: {{.Root}}</title>
+<style>
+body { font-family: sans-serif; margin: 2em auto; max-width: 760px; color: #222; }
+h1 { font-size: 1.4em; margin-bottom: 0.2em; }
+h2 { font-size: 1.1em; margin-top: 2em; border-bottom: 1px solid #ccc; }
+.fs { color: #555; font-family: monospace; }
+.note { color: #555; font-size: 0.9em; margin: 0.3em 0; }
+.partial { background: #fbe3e4; padding: 0.5em; border: 1px solid #e6a1a5; }
+table { border-collapse: collapse; }
+td { padding: 2px 12px 2px 0; }
+td.num { text-align: right; }
+</style>
+</head>
+<body>
+<h1>{{.Root}}</h1>
+<div class="fs">FS: {{.FS.Summary}}</div>
+<div class="note">Generated {{.Generated.Format "2006-01-02 15:04:05 MST"}} by pi profile, {{.Workers}} workers</div>
+{{if .Partial}}<p class="partial">Partial result: the scan was interrupted.</p>{{end}}
+<h2>Summary</h2>
+<table>
+{{range .Summary}}<tr><td>{{index . 0}}</td><td class="num">{{index . 1}}</td></tr>
+{{end}}</table>
+{{range .Sections}}{{if .Title}}<h2>{{.Title}}</h2>{{end}}
+{{if .Note}}<div class="note">{{.Note}}</div>{{end}}
+{{.Chart}}
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,87 @@
+package fs
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fwang2/pi/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func testWalkStat() (*WalkControl, *WalkStat) {
+	wc := &WalkControl{DoHist: true}
+	ws := &WalkStat{
+		RootPath:    os.TempDir(),
+		TotFileCnt:  4,
+		TotFileSize: 4096,
+		TotDirCnt:   2,
+		HistBins:    util.BinsToNum("4k,1m"),
+	}
+	ws.HistCounter = []int64{3, 1, 0}
+	return wc, ws
+}
+
+func TestReportJSON(t *testing.T) {
+	wc, ws := testWalkStat()
+	var buf bytes.Buffer
+	assert.Nil(t, NewReport(wc, ws).WriteJSON(&buf))
+
+	var r Report
+	assert.Nil(t, json.Unmarshal(buf.Bytes(), &r))
+	assert.Equal(t, ReportSchema, r.Schema)
+	assert.Equal(t, ReportVersion, r.Version)
+	assert.Equal(t, int64(1024), r.Totals.AvgFileSize)
+	assert.Equal(t, 3, len(r.Histogram))
+	assert.Equal(t, int64(util.GUARD), r.Histogram[2].UpperBound)
+}
+
+func TestReportCSV(t *testing.T) {
+	wc, ws := testWalkStat()
+	var buf bytes.Buffer
+	assert.Nil(t, NewReport(wc, ws).WriteCSV(&buf))
+
+	rows, err := csv.NewReader(&buf).ReadAll()
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"section", "key", "value"}, rows[0])
+	found := false
+	for _, row := range rows {
+		if row[0] == "totals" && row[1] == "files" {
+			assert.Equal(t, "4", row[2])
+			found = true
+		}
+	}
+	assert.True(t, found)
+}
+
+func TestReportHTML(t *testing.T) {
+	wc, ws := testWalkStat()
+	wc.DoAgeHist = true
+	wc.TopNfiles = true
+	ws.AgeBins = DefaultAgeBins
+	ws.AgeFiles = make([]int64, len(DefaultAgeBins)+1)
+	ws.AgeBytes = make([]int64, len(DefaultAgeBins)+1)
+	ws.AgeFiles[0], ws.AgeBytes[0] = 4, 4096
+	ws.TopNFileQ = util.NewSortedQueue(2)
+	ws.TopNFileQ.Put(util.Item{Name: "/tmp/<b>big</b>", Val: 4000})
+
+	r := NewReport(wc, ws)
+	assert.Equal(t, "mtime", r.AgeField)
+	assert.Equal(t, "<= 1d", r.AgeHistogram[0].Label)
+	assert.Equal(t, "> 3y", r.AgeHistogram[len(DefaultAgeBins)].Label)
+
+	var buf bytes.Buffer
+	assert.Nil(t, r.WriteHTML(&buf))
+	out := buf.String()
+	assert.Contains(t, out, "<!DOCTYPE html>")
+	assert.Contains(t, out, r.FS.Summary)
+	assert.Equal(t, 5, strings.Count(out, "<svg"))
+	assert.Contains(t, out, "&lt;b&gt;big&lt;/b&gt;")
+	assert.NotContains(t, out, "<b>big")
+	// self contained
+	assert.NotContains(t, out, "src=")
+	assert.NotContains(t, out, "<link")
+}
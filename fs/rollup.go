@@ -0,0 +1,226 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+)
+
+// size modes of a DirTree, also the choices of FileSize
+const (
+	SIZE_DEFAULT   = iota // allocated, but no more than the apparent size
+	SIZE_APPARENT         // st_size
+	SIZE_ALLOCATED        // 512B blocks
+)
+
+// EntrySize ... size of a file in one of the SIZE_* modes
+func EntrySize(mode int, dirPath string, fi os.FileInfo) int64 {
+	switch mode {
+	case SIZE_APPARENT:
+		return fi.Size()
+	case SIZE_ALLOCATED:
+		return 512 * int64(fi.Sys().(*syscall.Stat_t).Blocks)
+	}
+	return FileSize(dirPath, fi)
+}
+
+// DirNode ... a directory of a DirTree. Bytes and Inodes are those of
+// its own entries until the tree is rolled up, then of its subtree.
+type DirNode struct {
+	Path   string `json:"path"`
+	Depth  int    `json:"depth"`
+	Bytes  int64  `json:"bytes"`
+	Inodes int64  `json:"inodes"`
+}
+
+// TreeFile ... an entry other than a directory, kept by a DirTree
+type TreeFile struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// DirTree ... sizes of every directory under Root, fed by the results
+// of the walk and rolled up once it is done
+type DirTree struct {
+	Root  string
+	dirs  map[string]*DirNode
+	kids  map[string][]*DirNode // subdirectories, set by Rollup
+	files map[string][]TreeFile // only if the walk keeps them
+}
+
+// NewDirTree ...
+func NewDirTree(root string) *DirTree {
+	root = filepath.Clean(root)
+	t := &DirTree{Root: root, dirs: make(map[string]*DirNode),
+		files: make(map[string][]TreeFile)}
+	t.node(root)
+	return t
+}
+
+func (t *DirTree) node(dir string) *DirNode {
+	n := t.dirs[dir]
+	if n == nil {
+		n = &DirNode{Path: dir}
+		if dir != t.Root {
+			// relative to the root, which may itself be relative as "."
+			if rel, err := filepath.Rel(t.Root, dir); err == nil {
+				n.Depth = strings.Count(rel, string(filepath.Separator)) + 1
+			}
+		}
+		t.dirs[dir] = n
+	}
+	return n
+}
+
+// Add ... account bytes and inodes directly in dir
+func (t *DirTree) Add(dir string, bytes int64, inodes int64) {
+	n := t.node(dir)
+	n.Bytes += bytes
+	n.Inodes += inodes
+}
+
+// AddFiles ... keep the entries of dir that are not directories
+func (t *DirTree) AddFiles(dir string, files []TreeFile) {
+	t.files[dir] = append(t.files[dir], files...)
+}
+
+// Rollup ... fold every directory into its parent, one level at a time
+// from the deepest, so that each directory holds its subtree
+func (t *DirTree) Rollup() {
+	t.kids = make(map[string][]*DirNode)
+	var levels [][]*DirNode
+	for _, n := range t.dirs {
+		for len(levels) <= n.Depth {
+			levels = append(levels, nil)
+		}
+		levels[n.Depth] = append(levels[n.Depth], n)
+	}
+	for d := len(levels) - 1; d > 0; d-- {
+		for _, n := range levels[d] {
+			parent := filepath.Dir(n.Path)
+			p, ok := t.dirs[parent]
+			if !ok {
+				// not walked, e.g. an unreadable directory
+				p = t.node(parent)
+				levels[d-1] = append(levels[d-1], p)
+			}
+			p.Bytes += n.Bytes
+			p.Inodes += n.Inodes
+			t.kids[parent] = append(t.kids[parent], n)
+		}
+	}
+}
+
+// Top ... the n largest subtrees below the root
+func (t *DirTree) Top(n int, byInodes bool) []DirNode {
+	list := t.Entries(-1, byInodes)
+	for i, d := range list {
+		if d.Depth == 0 {
+			list = append(list[:i], list[i+1:]...)
+			break
+		}
+	}
+	if len(list) > n {
+		list = list[:n]
+	}
+	return list
+}
+
+// Children ... the subdirectories of a rolled up dir
+func (t *DirTree) Children(dir string) []DirNode {
+	list := make([]DirNode, 0, len(t.kids[dir]))
+	for _, n := range t.kids[dir] {
+		list = append(list, *n)
+	}
+	return list
+}
+
+// Files ... the entries of dir that are not directories
+func (t *DirTree) Files(dir string) []TreeFile {
+	return t.files[dir]
+}
+
+// Remove ... forget a removed entry of a rolled up tree, and take
+// its size off its ancestors
+func (t *DirTree) Remove(path string) {
+	path = filepath.Clean(path)
+	if path == t.Root {
+		return
+	}
+	parent := filepath.Dir(path)
+	var bytes, inodes int64
+	if n, ok := t.dirs[path]; ok {
+		bytes, inodes = n.Bytes, n.Inodes+1
+		t.forget(n)
+		kids := t.kids[parent]
+		for i, k := range kids {
+			if k == n {
+				t.kids[parent] = append(kids[:i:i], kids[i+1:]...)
+				break
+			}
+		}
+	} else {
+		name := filepath.Base(path)
+		files := t.files[parent]
+		i := 0
+		for i < len(files) && files[i].Name != name {
+			i++
+		}
+		if i == len(files) {
+			return
+		}
+		bytes, inodes = files[i].Bytes, 1
+		t.files[parent] = append(files[:i:i], files[i+1:]...)
+	}
+	for p := parent; ; p = filepath.Dir(p) {
+		if n, ok := t.dirs[p]; ok {
+			n.Bytes -= bytes
+			n.Inodes -= inodes
+		}
+		if p == t.Root || p == filepath.Dir(p) {
+			break
+		}
+	}
+}
+
+// forget ... drop n and its subtree
+func (t *DirTree) forget(n *DirNode) {
+	for _, k := range t.kids[n.Path] {
+		t.forget(k)
+	}
+	delete(t.dirs, n.Path)
+	delete(t.kids, n.Path)
+	delete(t.files, n.Path)
+}
+
+// Dir ... a directory of the tree, nil if unknown
+func (t *DirTree) Dir(dir string) *DirNode {
+	return t.dirs[filepath.Clean(dir)]
+}
+
+// Entries ... directories no deeper than maxDepth below the root, all of
+// them if maxDepth is negative, largest first. byInodes sorts on the
+// number of inodes instead of bytes.
+func (t *DirTree) Entries(maxDepth int, byInodes bool) []DirNode {
+	list := make([]DirNode, 0, len(t.dirs))
+	for _, n := range t.dirs {
+		if maxDepth < 0 || n.Depth <= maxDepth {
+			list = append(list, *n)
+		}
+	}
+	key := func(n DirNode) int64 {
+		if byInodes {
+			return n.Inodes
+		}
+		return n.Bytes
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if key(list[i]) != key(list[j]) {
+			return key(list[i]) > key(list[j])
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
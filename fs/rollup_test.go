@@ -0,0 +1,92 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/fwang2/pi/util"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirTreeRollup(t *testing.T) {
+	tree := NewDirTree("/r")
+	tree.Add("/r", 1, 3)
+	tree.Add("/r/a", 10, 2)
+	tree.Add("/r/b/c/d", 100, 1) // /r/b and /r/b/c were not walked
+	tree.Rollup()
+
+	assert.Equal(t, DirNode{"/r", 0, 111, 6}, *tree.Dir("/r"))
+	assert.Equal(t, DirNode{"/r/b", 1, 100, 1}, *tree.Dir("/r/b"))
+	assert.Equal(t, int64(100), tree.Dir("/r/b/c").Bytes)
+
+	var paths []string
+	for _, n := range tree.Entries(1, false) {
+		paths = append(paths, n.Path)
+	}
+	assert.Equal(t, []string{"/r", "/r/b", "/r/a"}, paths)
+	assert.Equal(t, "/r/a", tree.Entries(1, true)[1].Path)
+	assert.Equal(t, 5, len(tree.Entries(-1, false)))
+
+	root := NewDirTree("/")
+	root.Add("/x", 5, 1)
+	root.Rollup()
+	assert.Equal(t, 1, root.Dir("/x").Depth)
+	assert.Equal(t, int64(5), root.Dir("/").Bytes)
+
+	// a relative root, as given on the command line
+	for _, r := range []string{".", "r"} {
+		rel := NewDirTree(r)
+		rel.Add(r, 1, 1)
+		a := filepath.Join(r, "a")
+		rel.Add(filepath.Join(a, "b"), 100, 1)
+		rel.Rollup()
+		assert.Equal(t, 1, rel.Dir(a).Depth)
+		assert.Equal(t, int64(101), rel.Dir(r).Bytes)
+		assert.Equal(t, []DirNode{*rel.Dir(a)}, rel.Children(r))
+		assert.Equal(t, a, rel.Top(1, false)[0].Path)
+	}
+}
+
+func TestWalkTree(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+
+	for _, mode := range []int{SIZE_DEFAULT, SIZE_APPARENT, SIZE_ALLOCATED} {
+		ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+		RunProfile(&WalkControl{DoTree: true, SizeMode: mode}, ws)
+
+		fi, err := os.Lstat(filepath.Join(root, "b/c/f4"))
+		assert.Nil(t, err)
+		b := ws.Tree.Dir(filepath.Join(root, "b"))
+		assert.Equal(t, EntrySize(mode, root, fi), b.Bytes)
+		assert.Equal(t, int64(2), b.Inodes)
+		assert.Equal(t, int64(7), ws.Tree.Dir(root).Inodes)
+	}
+
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{DoTree: true, SizeMode: SIZE_APPARENT}, ws)
+	assert.Equal(t, int64(10000), ws.Tree.Dir(root).Bytes)
+}
+
+func TestWalkTopN(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	old := time.Now().Add(-48 * time.Hour)
+	assert.Nil(t, os.Chtimes(filepath.Join(root, "a/f3"), old, old))
+
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2,
+		TopNFileQ:  util.NewSortedQueue(2),
+		TopNDirQ:   util.NewSortedQueue(2),
+		TopNAtimeQ: util.NewSortedQueue(1)}
+	RunProfile(&WalkControl{TopNfiles: true, TopNdirs: true, TopNatime: true, DoTree: true}, ws)
+
+	oldest := ws.TopNAtimeQ.Items()
+	assert.Equal(t, filepath.Join(root, "a/f3"), oldest[0].Name)
+	assert.Equal(t, old.UnixNano(), -oldest[0].Val)
+
+	bySize := ws.Tree.Entries(1, false)
+	assert.Equal(t, filepath.Join(root, "a"), bySize[1].Path)
+	assert.Equal(t, int64(5000), bySize[1].Bytes)
+}
@@ -0,0 +1,23 @@
+package fs
+
+import (
+	"os"
+	"syscall"
+	"time"
+)
+
+// statMask ... there is no statx(2), the whole stat is read
+func statMask(wc *WalkControl) int {
+	return 0
+}
+
+// lstat ... os.Lstat, mask is for statx(2)
+func lstat(fname string, mask int) (os.FileInfo, error) {
+	return os.Lstat(fname)
+}
+
+// sysBirth ... the birth time a stat(2) has, zero if not kept
+func sysBirth(stat *syscall.Stat_t) (time.Time, bool) {
+	btime := time.Unix(int64(stat.Birthtimespec.Sec), int64(stat.Birthtimespec.Nsec))
+	return btime, stat.Birthtimespec.Sec != 0 || stat.Birthtimespec.Nsec != 0
+}
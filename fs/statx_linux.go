@@ -0,0 +1,161 @@
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/fwang2/pi/util"
+	"golang.org/x/sys/unix"
+)
+
+// noStatx ... set once statx(2) turns out missing, before Linux 4.11
+var noStatx int32
+
+// statxInfo ... an os.FileInfo from statx(2), the fields that were not
+// asked for are zero
+type statxInfo struct {
+	name  string
+	mode  os.FileMode
+	stat  syscall.Stat_t
+	btime time.Time // zero if unknown
+}
+
+func (s *statxInfo) Name() string      { return s.name }
+func (s *statxInfo) Size() int64       { return s.stat.Size }
+func (s *statxInfo) Mode() os.FileMode { return s.mode }
+func (s *statxInfo) IsDir() bool       { return s.mode.IsDir() }
+func (s *statxInfo) Sys() interface{}  { return &s.stat }
+
+func (s *statxInfo) ModTime() time.Time {
+	_, _, mtime := util.StatsTime(&s.stat)
+	return mtime
+}
+
+// BirthTime ... the creation time of the file, if the file system has it
+func (s *statxInfo) BirthTime() (time.Time, bool) {
+	return s.btime, !s.btime.IsZero()
+}
+
+// statMask ... the statx(2) fields a walk needs. The type, mode, owner,
+// links and inode are asked for always, they come with the inode. The
+// sizes and times only when used, on a parallel file system they may
+// take a round trip to the data servers.
+func statMask(wc *WalkControl) int {
+	if wc.Index != nil {
+		return unix.STATX_BASIC_STATS | unix.STATX_BTIME
+	}
+	mask := unix.STATX_TYPE | unix.STATX_MODE | unix.STATX_NLINK |
+		unix.STATX_UID | unix.STATX_GID | unix.STATX_INO
+	findc := wc.Findc
+	if !wc.NoTally || findc != nil && Has(findc.Flags, FB_SIZE) {
+		mask |= unix.STATX_SIZE | unix.STATX_BLOCKS
+	}
+
+	var times Bits
+	if findc != nil {
+		times = findc.Flags & (FB_ATIME | FB_CTIME | FB_MTIME | FB_BTIME)
+	}
+	if wc.DoAgeHist {
+		times = Set(times, ageField(wc.AgeField))
+	}
+	if wc.TopNatime {
+		times = Set(times, FB_ATIME)
+	}
+	if wc.TopNfiles || wc.TopNatime {
+		times = Set(times, FB_BTIME) // shown along, if known
+	}
+	for flag, bit := range map[Bits]int{
+		FB_ATIME: unix.STATX_ATIME,
+		FB_CTIME: unix.STATX_CTIME,
+		FB_MTIME: unix.STATX_MTIME,
+		FB_BTIME: unix.STATX_BTIME,
+	} {
+		if Has(times, flag) {
+			mask |= bit
+		}
+	}
+	return mask
+}
+
+// lstat ... os.Lstat by statx(2), asking for the fields in mask only,
+// all of them if mask is 0
+func lstat(fname string, mask int) (os.FileInfo, error) {
+	if mask == 0 || atomic.LoadInt32(&noStatx) != 0 {
+		return os.Lstat(fname)
+	}
+	var stx unix.Statx_t
+	flags := unix.AT_SYMLINK_NOFOLLOW | unix.AT_NO_AUTOMOUNT
+	err := unix.Statx(unix.AT_FDCWD, fname, flags, mask, &stx)
+	for err == unix.EINTR {
+		err = unix.Statx(unix.AT_FDCWD, fname, flags, mask, &stx)
+	}
+	if err == unix.ENOSYS {
+		atomic.StoreInt32(&noStatx, 1)
+		return os.Lstat(fname)
+	}
+	if err != nil {
+		return nil, &os.PathError{Op: "statx", Path: fname, Err: err}
+	}
+	return newStatxInfo(filepath.Base(fname), &stx), nil
+}
+
+func newStatxInfo(name string, stx *unix.Statx_t) *statxInfo {
+	s := &statxInfo{name: name, mode: fileMode(uint32(stx.Mode))}
+	st := &s.stat
+	st.Mode = uint32(stx.Mode)
+	st.Uid = stx.Uid
+	st.Gid = stx.Gid
+	st.Size = int64(stx.Size)
+	st.Blocks = int64(stx.Blocks)
+	setStatField(&st.Dev, unix.Mkdev(stx.Dev_major, stx.Dev_minor))
+	setStatField(&st.Rdev, unix.Mkdev(stx.Rdev_major, stx.Rdev_minor))
+	setStatField(&st.Ino, stx.Ino)
+	setStatField(&st.Nlink, uint64(stx.Nlink))
+	setStatField(&st.Blksize, uint64(stx.Blksize))
+	util.SetStatsTime(st, statxTime(stx.Atime), statxTime(stx.Ctime), statxTime(stx.Mtime))
+	if stx.Mask&unix.STATX_BTIME != 0 {
+		s.btime = statxTime(stx.Btime)
+	}
+	return s
+}
+
+func statxTime(ts unix.StatxTimestamp) time.Time {
+	return time.Unix(ts.Sec, int64(ts.Nsec))
+}
+
+// fileMode ... st_mode to an os.FileMode, as os.Lstat does
+func fileMode(m uint32) os.FileMode {
+	mode := os.FileMode(m & 0777)
+	switch m & syscall.S_IFMT {
+	case syscall.S_IFBLK:
+		mode |= os.ModeDevice
+	case syscall.S_IFCHR:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case syscall.S_IFDIR:
+		mode |= os.ModeDir
+	case syscall.S_IFIFO:
+		mode |= os.ModeNamedPipe
+	case syscall.S_IFLNK:
+		mode |= os.ModeSymlink
+	case syscall.S_IFSOCK:
+		mode |= os.ModeSocket
+	}
+	if m&syscall.S_ISGID != 0 {
+		mode |= os.ModeSetgid
+	}
+	if m&syscall.S_ISUID != 0 {
+		mode |= os.ModeSetuid
+	}
+	if m&syscall.S_ISVTX != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
+
+// sysBirth ... Linux has no birth time in a stat(2)
+func sysBirth(stat *syscall.Stat_t) (time.Time, bool) {
+	return time.Time{}, false
+}
@@ -0,0 +1,86 @@
+// +build linux
+
+package fs
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/sys/unix"
+)
+
+func TestLstatStatx(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+	assert.Nil(t, os.Symlink("f1", filepath.Join(root, "l1")))
+
+	for _, name := range []string{"f1", "a", "l1"} {
+		fname := filepath.Join(root, name)
+		want, err := os.Lstat(fname)
+		assert.Nil(t, err)
+		fi, err := lstat(fname, unix.STATX_BASIC_STATS)
+		assert.Nil(t, err)
+		assert.Equal(t, want.Name(), fi.Name())
+		assert.Equal(t, want.Mode(), fi.Mode(), name)
+		assert.Equal(t, want.Size(), fi.Size(), name)
+		assert.True(t, want.ModTime().Equal(fi.ModTime()), name)
+		assert.Equal(t, *want.Sys().(*syscall.Stat_t), *fi.Sys().(*syscall.Stat_t), name)
+	}
+
+	_, err := lstat(filepath.Join(root, "nowhere"), unix.STATX_TYPE)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestStatMask(t *testing.T) {
+	has := func(mask int, bits int) bool { return mask&bits == bits }
+
+	// find by name needs neither sizes nor times
+	findc := &FindControl{}
+	_, err := ParseExpr(findc, []string{"--name", "*.h5"})
+	assert.Nil(t, err)
+	mask := statMask(&WalkControl{Findc: findc, NoTally: true})
+	assert.True(t, has(mask, unix.STATX_TYPE|unix.STATX_MODE|unix.STATX_INO))
+	assert.False(t, has(mask, unix.STATX_SIZE))
+	assert.Equal(t, 0, mask&(unix.STATX_ATIME|unix.STATX_MTIME|unix.STATX_CTIME|unix.STATX_BTIME))
+
+	findc = &FindControl{}
+	_, err = ParseExpr(findc, []string{"--btime", "-7d", "--size", "+1m"})
+	assert.Nil(t, err)
+	mask = statMask(&WalkControl{Findc: findc, NoTally: true})
+	assert.True(t, has(mask, unix.STATX_BTIME|unix.STATX_SIZE))
+	assert.False(t, has(mask, unix.STATX_MTIME))
+
+	mask = statMask(&WalkControl{DoAgeHist: true})
+	assert.True(t, has(mask, unix.STATX_SIZE|unix.STATX_BLOCKS|unix.STATX_MTIME))
+	mask = statMask(&WalkControl{DoAgeHist: true, AgeField: FB_ATIME})
+	assert.True(t, has(mask, unix.STATX_ATIME))
+	assert.False(t, has(mask, unix.STATX_MTIME))
+	assert.True(t, has(statMask(&WalkControl{TopNfiles: true}), unix.STATX_BTIME))
+}
+
+func TestBirthTime(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+
+	fi, err := lstat(filepath.Join(root, "f1"), unix.STATX_TYPE|unix.STATX_BTIME)
+	assert.Nil(t, err)
+	btime, ok := fileBirth(fi)
+	if !ok {
+		t.Skip("no birth time on this file system")
+	}
+	assert.WithinDuration(t, time.Now(), btime, time.Minute)
+
+	findc := &FindControl{}
+	node, err := ParseExpr(findc, []string{"--btime", "-1d"})
+	assert.Nil(t, err)
+	assert.True(t, node.Eval(findc, root, fi))
+	// unknown, as from an index, never matches
+	assert.False(t, node.Eval(findc, root, fakeInfo{name: "f1"}))
+	node, err = ParseExpr(findc, []string{"--btime", "1d"})
+	assert.Nil(t, err)
+	assert.False(t, node.Eval(findc, root, fi))
+}
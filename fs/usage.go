@@ -0,0 +1,83 @@
+package fs
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"syscall"
+
+	"github.com/fwang2/pi/util"
+)
+
+// Usage ... space used by one owner
+type Usage struct {
+	Files     int64 `json:"files"`
+	Bytes     int64 `json:"bytes"`     // apparent size
+	Allocated int64 `json:"allocated"` // 512B blocks on disk
+}
+
+// OwnerUsage ... Usage of a uid or gid, with its resolved name
+type OwnerUsage struct {
+	ID   uint32 `json:"id"`
+	Name string `json:"name"`
+	Usage
+}
+
+func (u *Usage) add(other *Usage) {
+	u.Files += other.Files
+	u.Bytes += other.Bytes
+	u.Allocated += other.Allocated
+}
+
+// addUsage ... account a regular file to its owner in m
+func addUsage(m map[uint32]*Usage, id uint32, fi os.FileInfo, stat *syscall.Stat_t) {
+	u, ok := m[id]
+	if !ok {
+		u = new(Usage)
+		m[id] = u
+	}
+	u.Files++
+	u.Bytes += fi.Size()
+	u.Allocated += 512 * int64(stat.Blocks)
+}
+
+// mergeUsage ... fold per-directory usage into the totals
+func mergeUsage(dst map[uint32]*Usage, src map[uint32]*Usage) {
+	for id, u := range src {
+		if d, ok := dst[id]; ok {
+			d.add(u)
+		} else {
+			dst[id] = u
+		}
+	}
+}
+
+// SortedUsage ... owners by allocated bytes, largest first.
+// name resolves an id, unknown ids are shown as numbers.
+func SortedUsage(m map[uint32]*Usage, name func(uint32) (string, bool)) []OwnerUsage {
+	list := make([]OwnerUsage, 0, len(m))
+	for id, u := range m {
+		n, ok := name(id)
+		if !ok {
+			n = strconv.FormatUint(uint64(id), 10)
+		}
+		list = append(list, OwnerUsage{ID: id, Name: n, Usage: *u})
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Allocated != list[j].Allocated {
+			return list[i].Allocated > list[j].Allocated
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// UserUsage ... per-user usage of a finished walk
+func UserUsage(ws *WalkStat) []OwnerUsage {
+	return SortedUsage(ws.UserUsage, util.UserName)
+}
+
+// GroupUsage ... per-group usage of a finished walk
+func GroupUsage(ws *WalkStat) []OwnerUsage {
+	return SortedUsage(ws.GroupUsage, util.GroupName)
+}
@@ -0,0 +1,36 @@
+package fs
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortedUsage(t *testing.T) {
+	a := map[uint32]*Usage{
+		1: {Files: 1, Bytes: 10, Allocated: 512},
+		2: {Files: 2, Bytes: 20, Allocated: 4096},
+	}
+	b := map[uint32]*Usage{
+		1: {Files: 3, Bytes: 30, Allocated: 8192},
+		3: {Files: 1, Bytes: 1, Allocated: 0},
+	}
+	total := make(map[uint32]*Usage)
+	mergeUsage(total, a)
+	mergeUsage(total, b)
+
+	name := func(id uint32) (string, bool) {
+		if id == 3 {
+			return "", false
+		}
+		return "u" + strconv.Itoa(int(id)), true
+	}
+	list := SortedUsage(total, name)
+	assert.Equal(t, 3, len(list))
+	assert.Equal(t, "u1", list[0].Name)
+	assert.Equal(t, int64(4), list[0].Files)
+	assert.Equal(t, int64(8704), list[0].Allocated)
+	assert.Equal(t, "u2", list[1].Name)
+	assert.Equal(t, "3", list[2].Name)
+}
@@ -2,10 +2,11 @@ package fs
 
 import (
 	"fmt"
-	"io/ioutil"
 	"os"
 	"path"
+	"path/filepath"
 	"runtime"
+	"sort"
 	"syscall"
 	"time"
 
@@ -21,11 +22,33 @@ type ScanResult struct {
 	symlinkCnt  int64
 	pipeCnt     int64
 	dirCnt      int64
+	entryCnt    int64 // of any type
 	sparseCnt   int64
 	fileSizeAgg int64
 	fileSizeMax int64
+	treeBytes   int64 // in wc.SizeMode, for ws.Tree
+	treeFiles   []TreeFile
 	skipCnt     int64
-	dirs        []string // new dirs, new jobs
+	excludeCnt  int64
+	linkCnt     int64     // links to files counted already
+	linkSize    int64     // and their size
+	links       []LinkKey // inodes claimed in this dir
+	mounts      []string  // mount points not walked, with XDev
+	brokenLinks []string  // with Follow or CheckLinks
+	loopLinks   []string
+	outLinks    []string
+	visited     []LinkKey     // dirs claimed in this dir, with Follow
+	dirs        []string      // new dirs, new jobs
+	batched     bool          // a batch of the entries of dirPath only
+	stream      *dirStream    // dirPath has more to read
+	batch       []os.FileInfo // read, to be scanned by another job
+	users       map[uint32]*Usage
+	groups      map[uint32]*Usage
+	exts        map[string]*Usage
+	histCounter []int64
+	ageFiles    []int64
+	ageBytes    []int64
+	stopped     bool // not walked, the walk is stopping
 }
 
 // WalkStat ...
@@ -33,20 +56,61 @@ type WalkStat struct {
 	RootPath      string
 	NumOfWorkers  int
 	TotSkipped    int64
+	TotExcluded   int64 // entries left out by WalkControl.Exclude
 	TotFileCnt    int64
 	TotFileSize   int64
 	TotDirCnt     int64
 	TotSymlinkCnt int64
 	TotPipeCnt    int64
 	TotSparseCnt  int64
+	TotLinkCnt    int64 // hard links, or symlinks with Follow, to files counted already
+	TotLinkSize   int64 // size of these, not in TotFileSize
 	Rate          int64
 	TopNFileQ     *util.SortedQueue
 	TopNDirQ      *util.SortedQueue
+	TopNAtimeQ    *util.SortedQueue // Val is -atime, oldest first
 	Elapsed       time.Duration
 
 	// Histogram
 	HistBins    []int64
 	HistCounter []int64
+
+	// File age distribution, AgeFiles and AgeBytes have one more
+	// bucket than AgeBins for the files older than the last bin.
+	// Ages are taken at AgeNow, the start of the walk.
+	AgeBins  []time.Duration
+	AgeFiles []int64
+	AgeBytes []int64
+	AgeNow   time.Time
+
+	// Usage by uid and gid, and by file extension
+	UserUsage  map[uint32]*Usage
+	GroupUsage map[uint32]*Usage
+	ExtUsage   map[string]*Usage
+
+	// Inodes with several links, so they are counted once
+	Links *LinkSet
+
+	// Mount points not walked with XDev, sorted at the end of the walk
+	Mounts  []string
+	rootDev uint64
+
+	// Symlinks that can't be followed with Follow, and with CheckLinks
+	// those leading out of realRoot, sorted at the end of the walk.
+	// Directories are walked once with Follow, visited has them all.
+	BrokenLinks  []string
+	LoopLinks    []string
+	OutsideLinks []string
+	realRoot     string
+	visited      *LinkSet
+
+	// Subtree sizes, rolled up at the end of the walk
+	Tree *DirTree
+
+	statMask int // the statx(2) fields the walk needs
+
+	Partial       bool          // the walk was stopped before completion
+	elapsedBefore time.Duration // spent before a resume
 }
 
 // WalkControl ...
@@ -54,93 +118,158 @@ type WalkControl struct {
 	Verbose    bool
 	TopNfiles  bool
 	TopNdirs   bool
+	TopNatime  bool
 	DoHist     bool
+	DoAgeHist  bool
+	AgeField   Bits // FB_ATIME, FB_CTIME or FB_MTIME (default) for DoAgeHist
 	DoSparse   bool
-	ExcludeMap map[string]bool
+	CountLinks bool // count every hard link to a file, not only the first
+	XDev       bool // stay on the file system of the root
+	Follow     bool // walk symlinks as their targets
+	CheckLinks bool // find the broken, looping and outside symlinks
+	DoByUser   bool
+	DoByGroup  bool
+	DoByExt    bool
+	SniffExt   bool // classify files without extension by content
+	TopExts    int  // extensions in a Report, the rest is EXT_OTHER
+	DoTree     bool // subtree sizes in ws.Tree
+	TreeFiles  bool // keep the files in ws.Tree too
+	SizeMode   int  // one of SIZE_*, for ws.Tree
+	NoTally    bool // files are counted only, not lstat'ed for their sizes
+	BatchSize  int  // entries scanned by one job, DefaultBatchSize if 0
+	Exclude    *Excluder
 	Findc      *FindControl
 	DoProgress bool
+
+	// Checkpoint is saved every CheckpointInterval, if given.
+	// Resume continues the walk from a loaded checkpoint.
+	Checkpoint         string
+	CheckpointInterval time.Duration
+	Resume             *Checkpoint
+
+	// Index, if set, records every directory walked.
+	// FromIndex, if set, is read by RunIndex instead of walking.
+	Index     *IndexWriter
+	FromIndex string
+
+	// Stop is closed to end the walk early: no new directories are
+	// fed to the pool, jobs in flight finish and are counted.
+	Stop <-chan struct{}
 }
 
-func check_fsize(findc *FindControl, fsize int64) bool {
-	switch findc.SizeOp {
+func stopping(wc *WalkControl) bool {
+	select {
+	case <-wc.Stop:
+		return true
+	default:
+		return false
+	}
+}
+
+func compare_size(op string, size int64, fsize int64) bool {
+	switch op {
 	case GREAT_THAN:
-		if fsize > findc.Size {
-			return true
-		}
+		return fsize > size
 	case LESS_THAN:
-		if fsize < findc.Size {
-			return true
-		}
+		return fsize < size
 	case EQUAL:
-		if fsize == findc.Size {
-			return true
-		}
+		return fsize == size
 	}
 	return false
 }
 
-func check_fname(findc *FindControl, fname string) bool {
-	pattern := findc.Name
+func check_fsize(findc *FindControl, fsize int64) bool {
+	return compare_size(findc.SizeOp, findc.Size, fsize)
+}
+
+func match_name(pattern string, fname string) bool {
 	// convert a glob pattern (wildcard form) to regex needs extra work
 	// python has this nice fnmatch built in. For golang, this one seem works
 	// found, err := regexp.MatchString(pattern, fname)
-	found := fnmatch.Match(pattern, fname, 0)
-	return found
+	return fnmatch.Match(pattern, fname, 0)
+}
 
+func check_fname(findc *FindControl, fname string) bool {
+	return match_name(findc.Name, fname)
 }
 
-// depreciated
-func compare_time(findc *FindControl, t time.Time) bool {
-	unix_time := t.UnixNano()
-	start_time := findc.StartTime.UnixNano()
-	end_time := findc.EndTime.UnixNano()
-	if unix_time > start_time && unix_time < end_time {
-		return true
-	} else {
-		return false
+func match_time(flag Bits, r TimeRange, fi os.FileInfo) bool {
+	// https://golang.org/pkg/syscall/#Stat_t
+	stat := fi.Sys().(*syscall.Stat_t)
+	atime, ctime, mtime := util.StatsTime(stat)
+
+	switch {
+	case Has(flag, FB_ATIME):
+		return r.Contains(atime)
+	case Has(flag, FB_CTIME):
+		return r.Contains(ctime)
+	case Has(flag, FB_MTIME):
+		return r.Contains(mtime)
+	case Has(flag, FB_BTIME):
+		btime, ok := fileBirth(fi)
+		return ok && r.Contains(btime)
 	}
+	return false
 }
 
+// check_time ... every time predicate given must hold
 func check_time(findc *FindControl, fi os.FileInfo) bool {
-	// The following check won't do combination of
-	// of acm time, yet.
-	// https://golang.org/pkg/syscall/#Stat_t
 	stat := fi.Sys().(*syscall.Stat_t)
-	atime, mtime, ctime := util.StatsTime(stat)
+	atime, ctime, mtime := util.StatsTime(stat)
 
-	switch {
-	case Has(findc.Flags, FB_ATIME):
-		return atime.Before(findc.EndTime) &&
-			atime.After(findc.StartTime)
-	case Has(findc.Flags, FB_CTIME):
-		return ctime.Before(findc.EndTime) &&
-			ctime.After(findc.StartTime)
-	case Has(findc.Flags, FB_MTIME):
-		return mtime.Before(findc.EndTime) &&
-			mtime.After(findc.StartTime)
+	if Has(findc.Flags, FB_ATIME) && !findc.ATime.Contains(atime) {
+		return false
+	}
+	if Has(findc.Flags, FB_CTIME) && !findc.CTime.Contains(ctime) {
+		return false
+	}
+	if Has(findc.Flags, FB_MTIME) && !findc.MTime.Contains(mtime) {
+		return false
+	}
+	if Has(findc.Flags, FB_BTIME) {
+		btime, ok := fileBirth(fi)
+		return ok && findc.BTime.Contains(btime)
+	}
+	return true
+}
 
+func match_perm(op string, perm uint32, mode uint32) bool {
+	switch op {
+	case PERM_ALL:
+		return mode&perm == perm
+	case PERM_ANY:
+		// as GNU find, /000 matches everything
+		return perm == 0 || mode&perm != 0
 	}
-	return false
+	return mode == perm
 }
 
-func check_ftype(findc *FindControl, mode os.FileMode) bool {
+func match_ftype(flags Bits, mode os.FileMode) bool {
 	switch {
 	case mode.IsDir():
-		return Has(findc.Flags, FB_TYPE_D)
+		return Has(flags, FB_TYPE_D)
 	case mode.IsRegular():
-		return Has(findc.Flags, FB_TYPE_F)
+		return Has(flags, FB_TYPE_F)
 	case mode&os.ModeSymlink != 0:
-		return Has(findc.Flags, FB_TYPE_L)
+		return Has(flags, FB_TYPE_L)
 	}
 	return false
 }
 
-// find_ioi ... locate the item of interests (ioi). ioi is checked upon
-// name, size, type, time. We don't have support for OR combination. So
-// each condition is combined with AND.
+func check_ftype(findc *FindControl, mode os.FileMode) bool {
+	return match_ftype(findc.Flags, mode)
+}
+
+// find_ioi ... locate the item of interests (ioi). If an expression
+// is given, it decides. Otherwise ioi is checked upon name, size, type,
+// time, and each condition is combined with AND.
 func find_ioi(findc *FindControl, dir string, file os.FileInfo) (yes bool) {
 	var ioi_flag Bits
 
+	if findc.Expr != nil {
+		return findc.Expr.Eval(findc, dir, file)
+	}
+
 	if Has(findc.Flags, FB_NAME) {
 		if check_fname(findc, file.Name()) {
 			ioi_flag = Set(ioi_flag, IOI_NAME)
@@ -169,7 +298,7 @@ func find_ioi(findc *FindControl, dir string, file os.FileInfo) (yes bool) {
 		ioi_flag = Set(ioi_flag, IOI_SIZE)
 	}
 
-	if Has(findc.Flags, FB_ATIME|FB_CTIME|FB_MTIME) {
+	if Has(findc.Flags, FB_ATIME|FB_CTIME|FB_MTIME|FB_BTIME) {
 		if check_time(findc, file) {
 			ioi_flag = Set(ioi_flag, IOI_TIME)
 		} else {
@@ -181,7 +310,7 @@ func find_ioi(findc *FindControl, dir string, file os.FileInfo) (yes bool) {
 	}
 
 	if Has(findc.Flags, FB_TYPE_D|FB_TYPE_F|FB_TYPE_L) {
-		if check_ftype(findc, file.Mode()) {
+		if check_ftype(findc, fileType(file)) {
 			ioi_flag = Set(ioi_flag, IOI_TYPE)
 		} else {
 			yes = false
@@ -196,35 +325,166 @@ func find_ioi(findc *FindControl, dir string, file os.FileInfo) (yes bool) {
 
 }
 
-func check_dir_size(findc *FindControl, res *ScanResult) bool {
-	if Has(findc.Flags, FB_TYPE_D) && Has(findc.Flags, FB_SIZE) {
-		return check_fsize(findc, res.fileSizeAgg)
-	} else {
-		return false
+func check_dir_size(findc *FindControl, size int64) bool {
+	return findc.DirSizes() && check_fsize(findc, size)
+}
+
+// batchSize ... entries of a directory scanned by one job, a directory
+// is read at once when directories are found by size
+func batchSize(wc *WalkControl) int {
+	switch {
+	case wc.Findc != nil && wc.Findc.DirSizes():
+		return 0
+	case wc.BatchSize == 0:
+		return DefaultBatchSize
 	}
+	return wc.BatchSize
 }
 
 // Walk ...
 // args[0] passed as *WalkControl
 // args[1] passed as *WalkStat
 // args[2] passed as dir to be walked
+// args[3] passed as *dirStream, if dir is read in batches already
+//
+// A directory larger than a batch is not scanned by Walk: the result
+// has the batch read, for scanBatch, and the stream to read on, for
+// another Walk, so that both run at once.
 func Walk(args ...interface{}) interface{} {
 	var res ScanResult
 	var wc = args[0].(*WalkControl)
 	var ws = args[1].(*WalkStat)
 	res.dirPath = args[2].(string)
 
-	files, err := ioutil.ReadDir(res.dirPath)
+	// once started, a directory is read to its end, stopping or not
+	var s *dirStream
+	if len(args) > 3 {
+		s = args[3].(*dirStream)
+		res.batched = true
+	} else {
+		if stopping(wc) {
+			res.stopped = true
+			return res
+		}
+		var err error
+		if s, err = openDir(res.dirPath); err != nil {
+			if wc.Verbose {
+				log.Println(err)
+			}
+			return nil
+		}
+		s.mask = ws.statMask
+	}
+
+	files, err := s.next(batchSize(wc))
 	if err != nil {
 		if wc.Verbose {
 			log.Println(err)
 		}
-		return nil
+		if !res.batched {
+			s.close()
+			return nil
+		}
+		// the batches read before are merged already, the directory
+		// ends with them rather than being skipped
+		files = nil
+	} else if !s.eof {
+		res.stream, res.batch = s, files
+		return res
+	}
+	s.close()
+
+	files = statEntries(wc, &res, files)
+	if wc.Index != nil {
+		wc.Index.Add(res.dirPath, files)
+	}
+	scanEntries(wc, ws, &res, files)
+	if res.batched {
+		endOfDir(wc, ws, res.dirPath, s.count, 0)
+	}
+	return res
+}
+
+// scanBatch ... the job scanning a batch of a directory read by Walk
+// args[0] passed as *WalkControl
+// args[1] passed as *WalkStat
+// args[2] passed as the dir
+// args[3] passed as the batch, []os.FileInfo
+func scanBatch(args ...interface{}) interface{} {
+	var wc = args[0].(*WalkControl)
+	var ws = args[1].(*WalkStat)
+	res := ScanResult{dirPath: args[2].(string), batched: true}
+	files := statEntries(wc, &res, args[3].([]os.FileInfo))
+
+	if wc.Index != nil {
+		wc.Index.Add(res.dirPath, files)
+	}
+	scanEntries(wc, ws, &res, files)
+	return res
+}
+
+// endOfDir ... rank the directory dir once all of its entries are
+// scanned, size is the sum of the sizes of its files. A directory read
+// in batches has no sum, it is passed as 0: batchSize reads it at once
+// when directories are found by size, keep it so.
+func endOfDir(wc *WalkControl, ws *WalkStat, dir string, entries int64, size int64) {
+	// handle top N dir
+	if wc.TopNdirs {
+		ws.TopNDirQ.Put(util.Item{Name: dir, Val: entries})
 	}
 
+	// handle directory level find
+	if wc.Findc != nil && check_dir_size(wc.Findc, size) {
+		fmt.Printf("%s (%d)\n", dir, size)
+	}
+}
+
+// scanEntries ... tally the entries of res.dirPath, whether they come
+// from a walk or from an index
+func scanEntries(wc *WalkControl, ws *WalkStat, res *ScanResult, files []os.FileInfo) {
+	if wc.DoByUser {
+		res.users = make(map[uint32]*Usage)
+	}
+	if wc.DoByGroup {
+		res.groups = make(map[uint32]*Usage)
+	}
+	if wc.DoByExt {
+		res.exts = make(map[string]*Usage)
+	}
+	if wc.DoHist {
+		res.histCounter = make([]int64, len(ws.HistBins))
+	}
+	if wc.DoAgeHist {
+		res.ageFiles = make([]int64, len(ws.AgeBins)+1)
+		res.ageBytes = make([]int64, len(ws.AgeBins)+1)
+	}
+	res.entryCnt = int64(len(files))
+
 	for _, file := range files {
 
 		fname := path.Join(res.dirPath, file.Name())
+		mode := fileType(file)
+
+		if wc.Exclude.Excluded(fname, mode.IsDir()) {
+			log.Debug("Excluding ... ", fname)
+			res.excludeCnt++
+			res.entryCnt--
+			continue
+		}
+
+		// with Follow, a symlink stands for its target
+		isLink, outside := false, false
+		if wc.Follow && mode&os.ModeSymlink != 0 {
+			if target, ok := followLink(res, fname); ok {
+				file, mode, isLink = target, target.Mode(), true
+				if wc.CheckLinks || mode.IsRegular() {
+					outside = isOutside(ws.realRoot, fname)
+				}
+				if wc.CheckLinks && outside {
+					res.outLinks = append(res.outLinks, fname)
+				}
+			}
+		}
 
 		if wc.Findc != nil && find_ioi(wc.Findc, res.dirPath, file) {
 			fmt.Println(fname)
@@ -236,36 +496,124 @@ func Walk(args ...interface{}) interface{} {
 			}
 		}
 
-		mode := file.Mode()
+		// as du, a file with several hard links is counted once. So is
+		// a file reached through a symlink: where it is if that is under
+		// the root, else by the first link to it.
+		dup := false
+		if mode.IsRegular() && !wc.CountLinks && !wc.NoTally {
+			k, ok := linkKey(file)
+			if isLink {
+				k, ok = inodeKey(file), outside
+				dup = !outside
+			}
+			if ok {
+				if ws.Links.Claim(k) {
+					res.links = append(res.links, k)
+				} else {
+					dup = true
+				}
+			}
+		}
+
+		if wc.TreeFiles && !mode.IsDir() {
+			tf := TreeFile{Name: file.Name()}
+			if mode.IsRegular() && !dup {
+				tf.Bytes = EntrySize(wc.SizeMode, res.dirPath, file)
+			}
+			res.treeFiles = append(res.treeFiles, tf)
+		}
 
 		switch {
 		case mode.IsDir():
 			res.dirCnt++
 			newDir := path.Join(res.dirPath, file.Name())
 
-			if wc.ExcludeMap[newDir] {
-				log.Debug("Excluding ... ", newDir)
+			// the entry of a mount point is the root of the mounted fs
+			if wc.XDev && fileDev(file) != ws.rootDev {
+				log.Debug("Not crossing mount point ... ", newDir)
+				res.mounts = append(res.mounts, newDir)
 				break
 			}
+			if wc.Follow {
+				k := inodeKey(file)
+				if !ws.visited.Claim(k) {
+					// walked already, through another path
+					if isLink && isLinkLoop(newDir, res.dirPath) {
+						res.loopLinks = append(res.loopLinks, newDir)
+					}
+					break
+				}
+				res.visited = append(res.visited, k)
+			}
 
 			res.dirs = append(res.dirs, newDir) // save new dirs encountered
+		case dup:
+			res.linkCnt++
+			res.linkSize += FileSize(res.dirPath, file)
+			res.entryCnt--
 		case mode.IsRegular():
 			res.fileCnt++
+			if wc.NoTally {
+				break
+			}
 			fSize := FileSize(res.dirPath, file)
 
 			if fSize > res.fileSizeMax {
 				res.fileSizeMax = fSize
 			}
 			res.fileSizeAgg += fSize
+			if wc.DoTree {
+				res.treeBytes += EntrySize(wc.SizeMode, res.dirPath, file)
+			}
 
-			// handle top N files
+			// handle top N files, with their birth time if known
+			var born int64
+			if wc.TopNfiles || wc.TopNatime {
+				if btime, ok := fileBirth(file); ok {
+					born = btime.UnixNano()
+				}
+			}
 			if wc.TopNfiles {
-				ws.TopNFileQ.Put(util.Item{Name: fname, Val: fSize})
+				ws.TopNFileQ.Put(util.Item{Name: fname, Val: fSize, Time: born})
+			}
+			if wc.TopNatime {
+				atime, _, _ := util.StatsTime(file.Sys().(*syscall.Stat_t))
+				ws.TopNAtimeQ.Put(util.Item{Name: fname, Val: -atime.UnixNano(), Time: born})
+			}
+
+			// handle usage by owner
+			if wc.DoByUser || wc.DoByGroup {
+				stat := file.Sys().(*syscall.Stat_t)
+				if wc.DoByUser {
+					addUsage(res.users, stat.Uid, file, stat)
+				}
+				if wc.DoByGroup {
+					addUsage(res.groups, stat.Gid, file, stat)
+				}
+			}
+
+			// handle usage by extension
+			if wc.DoByExt {
+				ext := fileExt(file.Name())
+				if ext == "" {
+					ext = EXT_NONE
+					if wc.SniffExt && file.Size() > 0 {
+						ext = sniffType(fname)
+					}
+				}
+				addExtUsage(res.exts, ext, file, int64(file.Sys().(*syscall.Stat_t).Blocks))
 			}
 
 			// handle histogram
 			if wc.DoHist {
-				util.InsertLeft(ws.HistBins, ws.HistCounter, fSize)
+				util.InsertLeft(ws.HistBins, res.histCounter, fSize)
+			}
+
+			// handle file age
+			if wc.DoAgeHist {
+				i := ageBucket(ws.AgeBins, ws.AgeNow.Sub(fileTime(wc.AgeField, file)))
+				res.ageFiles[i]++
+				res.ageBytes[i] += fSize
 			}
 
 			// handle sparse file
@@ -281,20 +629,17 @@ func Walk(args ...interface{}) interface{} {
 
 		case mode&os.ModeSymlink != 0:
 			res.symlinkCnt++
+			// those that can't be followed are recorded already
+			if wc.CheckLinks && !wc.Follow {
+				res.addLink(CheckLink(ws.realRoot, res.dirPath, fname), fname)
+			}
 		case mode&os.ModeNamedPipe != 0:
 			res.pipeCnt++
 		}
 	}
-	// handle top N dir
-	if wc.TopNdirs {
-		ws.TopNDirQ.Put(util.Item{Name: res.dirPath, Val: int64(len(files))})
-	}
-
-	// handle directory level find
-	if wc.Findc != nil && check_dir_size(wc.Findc, &res) {
-		fmt.Printf("%s (%d)\n", res.dirPath, res.fileSizeAgg)
+	if !res.batched {
+		endOfDir(wc, ws, res.dirPath, int64(len(files)), res.fileSizeAgg)
 	}
-	return res
 }
 
 // WalkPrologue ...
@@ -326,35 +671,86 @@ func WalkProgressReport(ws *WalkStat) {
 // it recursively traverse into subdirectories, until no more no job to put
 // in to the pool. When all jobs are done, pool will return nil
 // the for-loop will break out.
+//
+// Every directory is added from here, outside the pool, so that pending
+// and the checkpoint are kept by one goroutine. The jobs are spread over
+// the workers round robin and stolen by idle ones, a subdirectory does
+// not stay on the worker of its parent.
 
 func RunProfile(wc *WalkControl, ws *WalkStat) {
+	start := time.Now()
+	if wc.Resume != nil {
+		wc.Resume.Restore(wc, ws)
+	}
+	initWalkStat(wc, ws)
 	mypool := pool.New(ws.NumOfWorkers)
 	mypool.Run()
-	mypool.Add(Walk, wc, ws, ws.RootPath)
+
+	// directories handed to the pool, but not merged into ws yet
+	pending := make(map[string]bool)
+	add := func(dir string) {
+		pending[dir] = true
+		mypool.Add(Walk, wc, ws, dir)
+	}
+	// jobs left of the directories read in batches, one job otherwise
+	batches := make(map[string]int)
+	jobsOf := func(dir string) int {
+		if n, ok := batches[dir]; ok {
+			return n
+		}
+		return 1
+	}
+	// done ... one job of dir is done, true if it was the last one
+	done := func(dir string) bool {
+		if n := jobsOf(dir) - 1; n > 0 {
+			batches[dir] = n
+			return false
+		}
+		delete(batches, dir)
+		delete(pending, dir)
+		return true
+	}
+
+	if wc.Resume != nil {
+		for _, d := range wc.Resume.Pending {
+			add(d)
+		}
+	} else {
+		add(ws.RootPath)
+	}
 
 	var tick <-chan time.Time
 	tick = time.Tick(500 * time.Millisecond)
 
+	var save <-chan time.Time
+	if wc.Checkpoint != "" {
+		save = time.Tick(wc.CheckpointInterval)
+	}
+	saveDue := false
+
 	for {
 		job := mypool.WaitForJob()
 		if job == nil {
 			break
 		}
 		if job.Result == nil {
+			done(job.Args[2].(string))
 			ws.TotSkipped++
-		} else {
-			result := job.Result.(ScanResult)
-			ws.TotFileCnt += result.fileCnt
-			ws.TotDirCnt += result.dirCnt
-			ws.TotFileSize += result.fileSizeAgg
-			ws.TotPipeCnt += result.pipeCnt
-			ws.TotSymlinkCnt += result.symlinkCnt
-			ws.TotSkipped += result.skipCnt
-			if wc.DoSparse {
-				ws.TotSparseCnt += result.sparseCnt
-			}
+		} else if result := job.Result.(ScanResult); result.stream != nil {
+			// the job goes on as two: reading on, scanning what was read
+			batches[result.dirPath] = jobsOf(result.dirPath) + 1
+			mypool.Add(Walk, wc, ws, result.dirPath, result.stream)
+			mypool.Add(scanBatch, wc, ws, result.dirPath, result.batch)
+		} else if !result.stopped {
+			done(result.dirPath)
+			mergeResult(wc, ws, &result)
 			for _, d := range result.dirs {
-				mypool.Add(Walk, wc, ws, d)
+				if stopping(wc) {
+					// left for a resume
+					pending[d] = true
+				} else {
+					add(d)
+				}
 			}
 		}
 
@@ -364,16 +760,160 @@ func RunProfile(wc *WalkControl, ws *WalkStat) {
 			if wc.DoProgress {
 				WalkProgressReport(ws)
 			}
+		case <-save:
+			saveDue = true
 		default:
 			break
 		}
+		// a directory read in part can't be resumed, wait for its end
+		if saveDue && len(batches) == 0 {
+			saveDue = false
+			cp := NewCheckpoint(wc, ws, pending, time.Since(start))
+			if err := cp.Save(wc.Checkpoint); err != nil {
+				log.Warningf("Can't save checkpoint %s: %v", wc.Checkpoint, err)
+			}
+		}
 	}
 	mypool.Stop()
+
+	if wc.DoTree {
+		ws.Tree.Rollup()
+	}
+	sort.Strings(ws.Mounts)
+	sort.Strings(ws.BrokenLinks)
+	sort.Strings(ws.LoopLinks)
+	sort.Strings(ws.OutsideLinks)
+	// only directories held back or stopped are left pending, a stop
+	// after the last one leaves the walk complete
+	ws.Partial = len(pending) > 0
+	if wc.Index != nil && !ws.Partial {
+		wc.Index.Complete()
+	}
+	if wc.Checkpoint == "" {
+		return
+	}
+	if ws.Partial {
+		cp := NewCheckpoint(wc, ws, pending, time.Since(start))
+		if err := cp.Save(wc.Checkpoint); err != nil {
+			log.Warningf("Can't save checkpoint %s: %v", wc.Checkpoint, err)
+		}
+	} else {
+		// the walk is complete, nothing left to resume
+		os.Remove(wc.Checkpoint)
+	}
+}
+
+func initWalkStat(wc *WalkControl, ws *WalkStat) {
+	if wc.DoAgeHist {
+		if ws.AgeBins == nil {
+			ws.AgeBins = DefaultAgeBins
+		}
+		if ws.AgeFiles == nil {
+			ws.AgeFiles = make([]int64, len(ws.AgeBins)+1)
+			ws.AgeBytes = make([]int64, len(ws.AgeBins)+1)
+		}
+		if ws.AgeNow.IsZero() {
+			ws.AgeNow = time.Now()
+		}
+	}
+	ws.statMask = statMask(wc)
+	if wc.DoTree && ws.Tree == nil {
+		ws.Tree = NewDirTree(ws.RootPath)
+	}
+	if wc.XDev {
+		if fi, err := os.Stat(ws.RootPath); err == nil {
+			ws.rootDev = fileDev(fi)
+		}
+	}
+	if wc.Exclude != nil && wc.Exclude.Root == "" {
+		wc.Exclude.Root = ws.RootPath
+	}
+	if wc.CheckLinks || wc.Follow {
+		ws.realRoot = ws.RootPath
+		if root, err := filepath.EvalSymlinks(ws.RootPath); err == nil {
+			ws.realRoot = root
+		}
+	}
+	if wc.Follow && ws.visited == nil {
+		ws.visited = NewLinkSet()
+		if fi, err := os.Stat(ws.RootPath); err == nil {
+			ws.visited.Commit([]LinkKey{inodeKey(fi)})
+		}
+	}
+	if !wc.CountLinks && ws.Links == nil {
+		ws.Links = NewLinkSet()
+	}
+	if wc.DoByUser && ws.UserUsage == nil {
+		ws.UserUsage = make(map[uint32]*Usage)
+	}
+	if wc.DoByGroup && ws.GroupUsage == nil {
+		ws.GroupUsage = make(map[uint32]*Usage)
+	}
+	if wc.DoByExt && ws.ExtUsage == nil {
+		ws.ExtUsage = make(map[string]*Usage)
+	}
+}
+
+// mergeResult ... fold the result of one directory into the totals
+func mergeResult(wc *WalkControl, ws *WalkStat, result *ScanResult) {
+	ws.TotFileCnt += result.fileCnt
+	ws.TotDirCnt += result.dirCnt
+	ws.TotFileSize += result.fileSizeAgg
+	ws.TotPipeCnt += result.pipeCnt
+	ws.TotSymlinkCnt += result.symlinkCnt
+	ws.TotSkipped += result.skipCnt
+	ws.TotExcluded += result.excludeCnt
+	ws.TotLinkCnt += result.linkCnt
+	ws.TotLinkSize += result.linkSize
+	if !wc.CountLinks {
+		ws.Links.Commit(result.links)
+	}
+	ws.Mounts = append(ws.Mounts, result.mounts...)
+	ws.BrokenLinks = append(ws.BrokenLinks, result.brokenLinks...)
+	ws.LoopLinks = append(ws.LoopLinks, result.loopLinks...)
+	ws.OutsideLinks = append(ws.OutsideLinks, result.outLinks...)
+	if wc.Follow {
+		ws.visited.Commit(result.visited)
+	}
+	if wc.DoSparse {
+		ws.TotSparseCnt += result.sparseCnt
+	}
+	if wc.DoByUser {
+		mergeUsage(ws.UserUsage, result.users)
+	}
+	if wc.DoByGroup {
+		mergeUsage(ws.GroupUsage, result.groups)
+	}
+	if wc.DoByExt {
+		mergeExtUsage(ws.ExtUsage, result.exts)
+	}
+	if wc.DoHist {
+		for i, v := range result.histCounter {
+			ws.HistCounter[i] += v
+		}
+	}
+	if wc.DoAgeHist {
+		for i := range result.ageFiles {
+			ws.AgeFiles[i] += result.ageFiles[i]
+			ws.AgeBytes[i] += result.ageBytes[i]
+		}
+	}
+	if wc.DoTree {
+		ws.Tree.Add(result.dirPath, result.treeBytes, result.entryCnt)
+		if wc.TreeFiles {
+			ws.Tree.AddFiles(result.dirPath, result.treeFiles)
+		}
+	}
+}
+
+// fileDev ... device of the file system fi is on
+func fileDev(fi os.FileInfo) uint64 {
+	return uint64(fi.Sys().(*syscall.Stat_t).Dev)
 }
 
 // CalcRate ...
 func CalcRate(start time.Time, ws *WalkStat) {
-	ws.Elapsed = time.Since(start)
+	ws.Elapsed = ws.elapsedBefore + time.Since(start)
 	rpms := float64(ws.TotDirCnt+ws.TotFileCnt) / float64(ws.Elapsed/time.Nanosecond)
 	ws.Rate = int64(rpms * 1e9)
 }
@@ -0,0 +1,43 @@
+package fs
+
+import (
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestScanEntriesXDev(t *testing.T) {
+	entries := []os.FileInfo{
+		fakeInfo{name: "home", mode: os.ModeDir, stat: syscall.Stat_t{Dev: 1}},
+		fakeInfo{name: "proc", mode: os.ModeDir, stat: syscall.Stat_t{Dev: 2}},
+		fakeInfo{name: "f", size: 10, stat: syscall.Stat_t{Dev: 1}},
+	}
+	wc := &WalkControl{XDev: true}
+	ws := &WalkStat{RootPath: "/", rootDev: 1}
+	res := ScanResult{dirPath: "/"}
+	scanEntries(wc, ws, &res, entries)
+	assert.Equal(t, []string{"/home"}, res.dirs)
+	assert.Equal(t, []string{"/proc"}, res.mounts)
+	assert.Equal(t, int64(2), res.dirCnt)
+
+	wc.XDev = false
+	res = ScanResult{dirPath: "/"}
+	scanEntries(wc, ws, &res, entries)
+	assert.Equal(t, []string{"/home", "/proc"}, res.dirs)
+	assert.Nil(t, res.mounts)
+}
+
+func TestWalkXDev(t *testing.T) {
+	root := createTree(t)
+	defer os.RemoveAll(root)
+
+	all := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{}, all)
+	ws := &WalkStat{RootPath: root, NumOfWorkers: 2}
+	RunProfile(&WalkControl{XDev: true}, ws)
+	assert.Equal(t, 0, len(ws.Mounts))
+	assert.Equal(t, all.TotFileCnt, ws.TotFileCnt)
+	assert.Equal(t, all.TotDirCnt, ws.TotDirCnt)
+}
@@ -6,15 +6,25 @@
  * file, You can obtain one at http://mozilla.org/MPL/2.0/. */
 
 // Package pool provides a worker pool.
+//
+// Jobs are scheduled by work stealing: each worker has a deque of its
+// own, a worker takes the newest job of its own deque and, when it runs
+// out, steals the oldest job of another. Idle workers sleep until a job
+// is added. The jobs added from outside are spread over the deques round
+// robin, those a job adds with AddCtx and the context it was given go to
+// the deque of its worker.
+//
+// A pool made by NewWithContext drops its queued jobs once the context
+// is done, and the jobs added by AddCtx are given a context that is done
+// then, or at their own deadline, to stop early.
 package pool
 
 import (
-	"container/list"
+	"context"
 	"fmt"
 	"log"
 	"sync"
 	"sync/atomic"
-	"time"
 )
 
 // Job holds all the data related to a worker's instance.
@@ -23,9 +33,11 @@ type Job struct {
 	Args      []interface{}
 	Result    interface{}
 	Err       error
-	added     chan bool // used by Pool.Add to wait for the supervisor
 	Worker_id uint
 	Job_id    uint64 // will wrap around on overflow
+
+	ctx context.Context // of AddCtx, nil otherwise
+	cf  func(context.Context, ...interface{}) interface{}
 }
 
 // stats is a structure holding statistical data about the pool.
@@ -35,28 +47,87 @@ type stats struct {
 	Completed int
 }
 
+// deque is the queue of jobs of one worker. The owner pops at the
+// back, thieves steal at the front.
+type deque struct {
+	mu   sync.Mutex
+	jobs []*Job
+	head int // jobs before head are stolen already
+}
+
+func (d *deque) push(job *Job) {
+	d.mu.Lock()
+	d.jobs = append(d.jobs, job)
+	d.mu.Unlock()
+}
+
+// pop takes the newest job, LIFO
+func (d *deque) pop() *Job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.head == len(d.jobs) {
+		return nil
+	}
+	last := len(d.jobs) - 1
+	job := d.jobs[last]
+	d.jobs[last] = nil
+	d.jobs = d.jobs[:last]
+	d.reset()
+	return job
+}
+
+// steal takes the oldest job, FIFO
+func (d *deque) steal() *Job {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.head == len(d.jobs) {
+		return nil
+	}
+	job := d.jobs[d.head]
+	d.jobs[d.head] = nil
+	d.head++
+	d.reset()
+	return job
+}
+
+// reset reclaims the room of the stolen jobs
+func (d *deque) reset() {
+	switch {
+	case d.head == len(d.jobs):
+		d.jobs, d.head = d.jobs[:0], 0
+	case d.head > len(d.jobs)/2:
+		n := copy(d.jobs, d.jobs[d.head:])
+		for i := n; i < len(d.jobs); i++ {
+			d.jobs[i] = nil
+		}
+		d.jobs, d.head = d.jobs[:n], 0
+	}
+}
+
 // Pool is the main data structure.
 type Pool struct {
-	workers_started      bool
-	supervisor_started   bool
-	num_workers          int
-	job_wanted_pipe      chan chan *Job
-	done_pipe            chan *Job
-	add_pipe             chan *Job
-	result_wanted_pipe   chan chan *Job
-	jobs_ready_to_run    *list.List
-	num_jobs_submitted   int
-	num_jobs_running     int
-	num_jobs_completed   int
-	jobs_completed       *list.List
-	interval             time.Duration // for sleeping, in ms
-	working_wanted_pipe  chan chan bool
-	stats_wanted_pipe    chan chan stats
-	worker_kill_pipe     chan bool
-	supervisor_kill_pipe chan bool
-	worker_wg            sync.WaitGroup
-	supervisor_wg        sync.WaitGroup
-	next_job_id          uint64
+	ctx             context.Context
+	workers_started bool
+	num_workers     int
+	deques          []*deque
+	next_deque      uint32
+	next_job_id     uint64
+	worker_wg       sync.WaitGroup
+
+	// idle workers wait on work for a job to be added, or to stop
+	mu       sync.Mutex
+	work     *sync.Cond
+	idle     int32
+	queued   int64 // jobs in the deques
+	running  int64
+	stopping int32
+
+	// WaitForJob and Wait wait on done for a job to complete
+	done_mu            sync.Mutex
+	done               *sync.Cond
+	jobs_completed     []*Job
+	num_jobs_submitted int
+	num_jobs_completed int
 }
 
 // subworker catches any panic while running the job.
@@ -65,117 +136,141 @@ func (pool *Pool) subworker(job *Job) {
 		if err := recover(); err != nil {
 			log.Println("panic while running job:", err)
 			job.Result = nil
-			job.Err = fmt.Errorf(err.(string))
+			job.Err = fmt.Errorf("%v", err)
 		}
 	}()
-	job.Result = job.F(job.Args...)
+	if job.cf == nil {
+		job.Result = job.F(job.Args...)
+		return
+	}
+	ctx, cancel := pool.jobContext(job)
+	defer cancel()
+	job.Result = job.cf(ctx, job.Args...)
+}
+
+// workerKey ... the context value telling AddCtx which worker runs the
+// job adding to the pool
+type workerKey struct{}
+
+type workerCtx struct {
+	pool *Pool
+	id   uint
+	base context.Context // the job's own context
+	done <-chan struct{} // of the context the job was given
+}
+
+// jobContext ... the context a job of AddCtx runs with, done when its
+// own or the pool's is.
+func (pool *Pool) jobContext(job *Job) (context.Context, context.CancelFunc) {
+	ctx, cancel := job.ctx, func() {}
+	if pool.ctx.Done() != nil && job.ctx != pool.ctx {
+		ctx, cancel = context.WithCancel(job.ctx)
+		go func() {
+			select {
+			case <-pool.ctx.Done():
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+	}
+	w := &workerCtx{pool: pool, id: job.Worker_id, base: job.ctx, done: ctx.Done()}
+	return context.WithValue(ctx, workerKey{}, w), cancel
 }
 
-// worker gets a job from the job_pipe, passes it to a
-// subworker and puts the job in the done_pipe when finished.
+// canceled ... why a job is dropped rather than run, nil to run it.
+func (pool *Pool) canceled(job *Job) error {
+	if err := pool.ctx.Err(); err != nil {
+		return err
+	}
+	if job.ctx != nil {
+		return job.ctx.Err()
+	}
+	return nil
+}
+
+// worker runs the jobs of its deque, steals from the others when it has
+// none left, and sleeps when no deque has any.
 func (pool *Pool) worker(worker_id uint) {
-	job_pipe := make(chan *Job)
-WORKER_LOOP:
+	defer pool.worker_wg.Done()
 	for {
-		pool.job_wanted_pipe <- job_pipe
-		job := <-job_pipe
+		job := pool.next(int(worker_id))
 		if job == nil {
-			time.Sleep(pool.interval * time.Millisecond)
+			return
+		}
+		job.Worker_id = worker_id
+		if err := pool.canceled(job); err != nil {
+			job.Err = err
 		} else {
-			job.Worker_id = worker_id
 			pool.subworker(job)
-			pool.done_pipe <- job
 		}
-		select {
-		case <-pool.worker_kill_pipe:
-			break WORKER_LOOP
-		default:
+
+		pool.done_mu.Lock()
+		pool.jobs_completed = append(pool.jobs_completed, job)
+		pool.num_jobs_completed++
+		atomic.AddInt64(&pool.running, -1)
+		pool.done_mu.Unlock()
+		pool.done.Broadcast()
+	}
+}
+
+// next blocks until a job is found for worker i, nil when stopping.
+func (pool *Pool) next(i int) *Job {
+	for atomic.LoadInt32(&pool.stopping) == 0 {
+		if job := pool.take(i); job != nil {
+			atomic.AddInt64(&pool.queued, -1)
+			atomic.AddInt64(&pool.running, 1)
+			return job
+		}
+		// idle is raised before queued is checked, so that Add, which
+		// raises queued before it checks idle, can't miss a sleeper
+		pool.mu.Lock()
+		atomic.AddInt32(&pool.idle, 1)
+		if atomic.LoadInt64(&pool.queued) == 0 && atomic.LoadInt32(&pool.stopping) == 0 {
+			pool.work.Wait()
+		}
+		atomic.AddInt32(&pool.idle, -1)
+		pool.mu.Unlock()
+	}
+	return nil
+}
+
+// take pops from deque i, or steals from the others in turn.
+func (pool *Pool) take(i int) *Job {
+	n := len(pool.deques)
+	if job := pool.deques[i%n].pop(); job != nil {
+		return job
+	}
+	for k := 1; k < n; k++ {
+		if job := pool.deques[(i+k)%n].steal(); job != nil {
+			return job
 		}
 	}
-	pool.worker_wg.Done()
+	return nil
 }
 
 // New() creates a new Pool.
 func New(workers int) (pool *Pool) {
-	pool = new(Pool)
-	pool.num_workers = workers
-	pool.job_wanted_pipe = make(chan chan *Job)
-	pool.done_pipe = make(chan *Job)
-	pool.add_pipe = make(chan *Job)
-	pool.result_wanted_pipe = make(chan chan *Job)
-	pool.jobs_ready_to_run = list.New()
-	pool.jobs_completed = list.New()
-	pool.working_wanted_pipe = make(chan chan bool)
-	pool.stats_wanted_pipe = make(chan chan stats)
-	pool.worker_kill_pipe = make(chan bool)
-	pool.supervisor_kill_pipe = make(chan bool)
-	pool.interval = 1
-	pool.next_job_id = 0
-	// start the supervisor here so we can accept jobs before a Run call
-	pool.startSupervisor()
-	return
+	return NewWithContext(context.Background(), workers)
 }
 
-// the supervisor feeds jobs to workers and keeps track of them.
-func (pool *Pool) supervisor() {
-SUPERVISOR_LOOP:
-	for {
-		select {
-		// new job
-		case job := <-pool.add_pipe:
-			pool.jobs_ready_to_run.PushBack(job)
-			pool.num_jobs_submitted++
-			job.added <- true
-		// send jobs to the workers
-		case job_pipe := <-pool.job_wanted_pipe:
-			element := pool.jobs_ready_to_run.Front()
-			var job *Job = nil
-			if element != nil {
-				job = element.Value.(*Job)
-				pool.num_jobs_running++
-				pool.jobs_ready_to_run.Remove(element)
-			}
-			job_pipe <- job
-		// job completed
-		case job := <-pool.done_pipe:
-			pool.num_jobs_running--
-			pool.jobs_completed.PushBack(job)
-			pool.num_jobs_completed++
-		// wait for job
-		case result_pipe := <-pool.result_wanted_pipe:
-			close_pipe := false
-			job := (*Job)(nil)
-			element := pool.jobs_completed.Front()
-			if element != nil {
-				job = element.Value.(*Job)
-				pool.jobs_completed.Remove(element)
-			} else {
-				if pool.num_jobs_running == 0 && pool.num_jobs_completed == pool.num_jobs_submitted {
-					close_pipe = true
-				}
-			}
-			if close_pipe {
-				close(result_pipe)
-			} else {
-				result_pipe <- job
-			}
-		// is the pool working or just lazing on a Sunday afternoon?
-		case working_pipe := <-pool.working_wanted_pipe:
-			working := true
-			if pool.jobs_ready_to_run.Len() == 0 && pool.num_jobs_running == 0 {
-				working = false
-			}
-			working_pipe <- working
-		// stats
-		case stats_pipe := <-pool.stats_wanted_pipe:
-			pool_stats := stats{pool.num_jobs_submitted, pool.num_jobs_running, pool.num_jobs_completed}
-			stats_pipe <- pool_stats
-		// stopping
-		case <-pool.supervisor_kill_pipe:
-			break SUPERVISOR_LOOP
-		}
+// NewWithContext() creates a new Pool canceled with ctx. Once ctx is done
+// the jobs queued are dropped: they complete with ctx.Err() as Err,
+// without running.
+func NewWithContext(ctx context.Context, workers int) (pool *Pool) {
+	pool = new(Pool)
+	pool.ctx = ctx
+	pool.num_workers = workers
+	pool.deques = make([]*deque, workers)
+	if workers < 1 {
+		// jobs can be added, none will run
+		pool.deques = make([]*deque, 1)
 	}
-	pool.supervisor_wg.Done()
+	for i := range pool.deques {
+		pool.deques[i] = new(deque)
+	}
+	pool.work = sync.NewCond(&pool.mu)
+	pool.done = sync.NewCond(&pool.done_mu)
+	return
 }
 
 // Run starts the Pool by launching the workers.
@@ -185,56 +280,73 @@ func (pool *Pool) Run() {
 	if pool.workers_started {
 		panic("trying to start a pool that's already running")
 	}
+	atomic.StoreInt32(&pool.stopping, 0)
 	for i := uint(0); i < uint(pool.num_workers); i++ {
 		pool.worker_wg.Add(1)
 		go pool.worker(i)
 	}
 	pool.workers_started = true
-	// handle the supervisor
-	if !pool.supervisor_started {
-		pool.startSupervisor()
-	}
 }
 
 // Stop will signal the workers to exit and wait for them to actually do that.
-// It also releases any other resources (e.g.: it stops the supervisor goroutine)
-// so call this method when you're done with the Pool instance to allow the GC
-// to do its job.
+// The jobs running are completed, those queued are kept for another Run.
 func (pool *Pool) Stop() {
 	if !pool.workers_started {
 		panic("trying to stop a pool that's already stopped")
 	}
-	// stop the workers
-	for i := 0; i < pool.num_workers; i++ {
-		pool.worker_kill_pipe <- true
-	}
+	atomic.StoreInt32(&pool.stopping, 1)
+	pool.mu.Lock()
+	pool.work.Broadcast()
+	pool.mu.Unlock()
 	pool.worker_wg.Wait()
-	// set the flag
 	pool.workers_started = false
-	// handle the supervisor
-	if pool.supervisor_started {
-		pool.stopSupervisor()
-	}
 }
 
-func (pool *Pool) startSupervisor() {
-	pool.supervisor_wg.Add(1)
-	go pool.supervisor()
-	pool.supervisor_started = true
+// Add creates a Job from the given function and args and
+// adds it to the Pool.
+func (pool *Pool) Add(f func(...interface{}) interface{}, args ...interface{}) {
+	pool.spread(&Job{F: f, Args: args})
 }
 
-func (pool *Pool) stopSupervisor() {
-	pool.supervisor_kill_pipe <- true
-	pool.supervisor_wg.Wait()
-	pool.supervisor_started = false
+// AddCtx adds a job that is given a context, done when ctx or the pool's
+// context is, so that it can stop early. A job whose ctx is done before
+// it starts, as at a deadline, is dropped with ctx.Err() as Err.
+//
+// A job adding more jobs with the context it was given queues them on
+// the deque of its own worker, they keep the job's context and outlive
+// it. A context derived from it ends with the job.
+func (pool *Pool) AddCtx(ctx context.Context, f func(context.Context, ...interface{}) interface{}, args ...interface{}) {
+	job := &Job{Args: args, ctx: ctx, cf: f}
+	if w, ok := ctx.Value(workerKey{}).(*workerCtx); ok && w.pool == pool {
+		if ctx.Done() == w.done {
+			job.ctx = w.base
+		}
+		pool.push(int(w.id), job)
+		return
+	}
+	pool.spread(job)
 }
 
-// Add creates a Job from the given function and args and
-// adds it to the Pool.
-func (pool *Pool) Add(f func(...interface{}) interface{}, args ...interface{}) {
-	job := &Job{f, args, nil, nil, make(chan bool), 0, pool.getNextJobId()}
-	pool.add_pipe <- job
-	<-job.added
+// spread ... queue a job added from outside, round robin on the deques
+func (pool *Pool) spread(job *Job) {
+	i := atomic.AddUint32(&pool.next_deque, 1) % uint32(len(pool.deques))
+	pool.push(int(i), job)
+}
+
+// push ... queue a job on deque i, and wake a worker if any sleeps
+func (pool *Pool) push(i int, job *Job) {
+	job.Job_id = pool.getNextJobId()
+	pool.done_mu.Lock()
+	pool.num_jobs_submitted++
+	pool.done_mu.Unlock()
+
+	pool.deques[i%len(pool.deques)].push(job)
+	atomic.AddInt64(&pool.queued, 1)
+	if atomic.LoadInt32(&pool.idle) > 0 {
+		pool.mu.Lock()
+		pool.work.Signal()
+		pool.mu.Unlock()
+	}
 }
 
 // job IDs start from 1
@@ -244,58 +356,47 @@ func (pool *Pool) getNextJobId() uint64 {
 
 // Wait blocks until all the jobs in the Pool are done.
 func (pool *Pool) Wait() {
-	working_pipe := make(chan bool)
-	for {
-		pool.working_wanted_pipe <- working_pipe
-		if !<-working_pipe {
-			break
-		}
-		time.Sleep(pool.interval * time.Millisecond)
+	pool.done_mu.Lock()
+	defer pool.done_mu.Unlock()
+	for pool.num_jobs_completed != pool.num_jobs_submitted {
+		pool.done.Wait()
 	}
 }
 
 // Results retrieves the completed jobs.
 func (pool *Pool) Results() (res []*Job) {
-	res = make([]*Job, pool.jobs_completed.Len())
-	i := 0
-	for e := pool.jobs_completed.Front(); e != nil; e = e.Next() {
-		res[i] = e.Value.(*Job)
-		i++
+	pool.done_mu.Lock()
+	defer pool.done_mu.Unlock()
+	res = pool.jobs_completed
+	pool.jobs_completed = nil
+	if res == nil {
+		res = []*Job{}
 	}
-	pool.jobs_completed = list.New()
 	return
 }
 
 // WaitForJob blocks until a completed job is available and returns it.
 // If there are no jobs running, it returns nil.
 func (pool *Pool) WaitForJob() *Job {
-	result_pipe := make(chan *Job)
-	var job *Job
-	var ok bool
-	for {
-		pool.result_wanted_pipe <- result_pipe
-		job, ok = <-result_pipe
-		if !ok {
+	pool.done_mu.Lock()
+	defer pool.done_mu.Unlock()
+	for len(pool.jobs_completed) == 0 {
+		if pool.num_jobs_completed == pool.num_jobs_submitted {
 			// no more results available
 			return nil
 		}
-		if job == (*Job)(nil) {
-			// no result available right now but there are jobs running
-			time.Sleep(pool.interval * time.Millisecond)
-		} else {
-			break
-		}
+		pool.done.Wait()
 	}
+	job := pool.jobs_completed[0]
+	pool.jobs_completed[0] = nil
+	pool.jobs_completed = pool.jobs_completed[1:]
 	return job
 }
 
 // Status returns a "stats" instance.
 func (pool *Pool) Status() stats {
-	stats_pipe := make(chan stats)
-	if pool.supervisor_started {
-		pool.stats_wanted_pipe <- stats_pipe
-		return <-stats_pipe
-	}
-	// the supervisor wasn't started so we return a zeroed structure
-	return stats{}
+	pool.done_mu.Lock()
+	defer pool.done_mu.Unlock()
+	running := int(atomic.LoadInt64(&pool.running))
+	return stats{pool.num_jobs_submitted, running, pool.num_jobs_completed}
 }
@@ -8,9 +8,13 @@
 package pool
 
 import (
+	"context"
+	"fmt"
 	"math"
 	"runtime"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func work(args ...interface{}) interface{} {
@@ -133,3 +137,276 @@ func TestCorrectness(t *testing.T) {
 	validateResult(t, processResultsWhenAvailable(t, mypool), reference, "stop/start the pool")
 	mypool.Stop()
 }
+
+func TestAddFromJobs(t *testing.T) {
+	// each job adds two more down to depth 0, as a walk of a binary tree
+	mypool := New(8)
+	var spawn func(ctx context.Context, args ...interface{}) interface{}
+	spawn = func(ctx context.Context, args ...interface{}) interface{} {
+		depth := args[0].(int)
+		if depth > 0 {
+			mypool.AddCtx(ctx, spawn, depth-1)
+			mypool.AddCtx(ctx, spawn, depth-1)
+		}
+		return depth
+	}
+	mypool.AddCtx(context.Background(), spawn, 10)
+	mypool.Run()
+	n := 0
+	for job := mypool.WaitForJob(); job != nil; job = mypool.WaitForJob() {
+		n++
+	}
+	mypool.Stop()
+	if n != 1<<11-1 {
+		t.Error(n, "jobs done, want", 1<<11-1)
+	}
+	if st := mypool.Status(); st.Submitted != n || st.Completed != n || st.Running != 0 {
+		t.Error("bad status", st)
+	}
+}
+
+func TestAddLocal(t *testing.T) {
+	mypool := New(4)
+	mypool.Run()
+	defer mypool.Stop()
+
+	// keep 3 workers busy, so that nothing is stolen from the fourth
+	release := make(chan struct{})
+	started := make(chan struct{}, 3)
+	for i := 0; i < 3; i++ {
+		mypool.Add(func(args ...interface{}) interface{} {
+			started <- struct{}{}
+			<-release
+			return nil
+		})
+	}
+	for i := 0; i < 3; i++ {
+		<-started
+	}
+
+	queued := make(chan []int, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	mypool.AddCtx(ctx, func(ctx context.Context, args ...interface{}) interface{} {
+		for i := 0; i < 3; i++ {
+			mypool.AddCtx(ctx, func(ctx context.Context, args ...interface{}) interface{} {
+				return spin(args...)
+			}, i)
+		}
+		var n []int
+		for _, d := range mypool.deques {
+			d.mu.Lock()
+			n = append(n, len(d.jobs)-d.head)
+			d.mu.Unlock()
+		}
+		queued <- n
+		return "parent"
+	})
+	n := <-queued
+	local := 0
+	for _, q := range n {
+		if q == 3 {
+			local++
+		} else if q != 0 {
+			local = -1
+			break
+		}
+	}
+	if local != 1 {
+		t.Error("jobs added by a job not on one deque:", n)
+	}
+
+	// they run after the job that added them, on its worker
+	var parent uint = math.MaxUint32
+	var children []uint
+	for done := 0; done < 4; done++ {
+		job := mypool.WaitForJob()
+		if job.Err != nil {
+			t.Error("job", job.Job_id, job.Err)
+		}
+		if job.Result == "parent" {
+			parent = job.Worker_id
+		} else {
+			children = append(children, job.Worker_id)
+		}
+	}
+	for _, w := range children {
+		if w != parent {
+			t.Error("job added by worker", parent, "run by", w)
+		}
+	}
+	close(release)
+	mypool.Wait()
+}
+
+// block ... a job that runs until its context is done
+func block(ctx context.Context, args ...interface{}) interface{} {
+	started := args[0].(chan struct{})
+	close(started)
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	mypool := NewWithContext(ctx, 1)
+	mypool.Run()
+
+	started := make(chan struct{})
+	mypool.AddCtx(context.Background(), block, started)
+	<-started
+	// queued behind the job running
+	var ran int32
+	for i := 0; i < 10; i++ {
+		mypool.Add(func(args ...interface{}) interface{} {
+			atomic.AddInt32(&ran, 1)
+			return nil
+		})
+	}
+	cancel()
+
+	n, dropped := 0, 0
+	for job := mypool.WaitForJob(); job != nil; job = mypool.WaitForJob() {
+		n++
+		switch {
+		case job.Err == context.Canceled:
+			dropped++
+		case job.Result != context.Canceled:
+			t.Error("running job not signaled:", job.Result, job.Err)
+		}
+	}
+	mypool.Stop()
+	if n != 11 || dropped != 10 || ran != 0 {
+		t.Error(n, "done,", dropped, "dropped,", ran, "ran, want 11, 10, 0")
+	}
+
+	// added after the cancel
+	mypool.Run()
+	mypool.Add(spin, 1)
+	if job := mypool.WaitForJob(); job == nil || job.Err != context.Canceled {
+		t.Error("job added after cancel not dropped:", job)
+	}
+	mypool.Stop()
+}
+
+func TestJobDeadline(t *testing.T) {
+	mypool := New(1)
+	mypool.Run()
+
+	// signaled at its deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	mypool.AddCtx(ctx, block, make(chan struct{}))
+	job := mypool.WaitForJob()
+	if job.Err != nil || job.Result != context.DeadlineExceeded {
+		t.Error("job not stopped at its deadline:", job.Result, job.Err)
+	}
+
+	// past its deadline before it starts
+	ctx, cancel = context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	started := make(chan struct{})
+	mypool.AddCtx(ctx, block, started)
+	job = mypool.WaitForJob()
+	if job.Err != context.DeadlineExceeded {
+		t.Error("job past its deadline not dropped:", job.Err)
+	}
+	select {
+	case <-started:
+		t.Error("job past its deadline ran")
+	default:
+	}
+
+	// the others run on
+	mypool.Add(spin, 1)
+	if job = mypool.WaitForJob(); job.Err != nil || job.Result == nil {
+		t.Error("job failed after a deadline:", job.Err)
+	}
+	mypool.Stop()
+}
+
+// spin ... a job of about a microsecond, as a stat of a cached inode
+func spin(args ...interface{}) interface{} {
+	x := args[0].(int)
+	for i := 0; i < 200; i++ {
+		x = x*31 + i
+	}
+	return x
+}
+
+var benchWorkers = []int{1, 2, 4, 8, 16, 32, 64, 128}
+
+func reportJobs(b *testing.B, start time.Time) {
+	b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "jobs/s")
+}
+
+// BenchmarkFlat ... b.N independent jobs added up front, results
+// collected one at a time
+func BenchmarkFlat(b *testing.B) {
+	for _, workers := range benchWorkers {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			mypool := New(workers)
+			mypool.Run()
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				mypool.Add(spin, i)
+			}
+			for job := mypool.WaitForJob(); job != nil; job = mypool.WaitForJob() {
+			}
+			reportJobs(b, start)
+			mypool.Stop()
+		})
+	}
+}
+
+// BenchmarkTree ... each job adds up to fanout more to its own deque,
+// until b.N jobs are added, the consumer only collects the results
+func BenchmarkTree(b *testing.B) {
+	const fanout = 4
+	for _, workers := range benchWorkers {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			mypool := New(workers)
+			mypool.Run()
+			start := time.Now()
+			added := int64(1)
+			var spawn func(ctx context.Context, args ...interface{}) interface{}
+			spawn = func(ctx context.Context, args ...interface{}) interface{} {
+				for k := 0; k < fanout; k++ {
+					if atomic.AddInt64(&added, 1) > int64(b.N) {
+						break
+					}
+					mypool.AddCtx(ctx, spawn, k)
+				}
+				return spin(args...)
+			}
+			mypool.AddCtx(context.Background(), spawn, 0)
+			for job := mypool.WaitForJob(); job != nil; job = mypool.WaitForJob() {
+			}
+			reportJobs(b, start)
+			mypool.Stop()
+		})
+	}
+}
+
+// BenchmarkResults ... the consumer adds the jobs each result asks for,
+// as a walk adds the subdirectories found, until b.N jobs are done
+func BenchmarkResults(b *testing.B) {
+	const fanout = 4
+	for _, workers := range benchWorkers {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			mypool := New(workers)
+			mypool.Run()
+			start := time.Now()
+			mypool.Add(spin, 0)
+			added := 1
+			for job := mypool.WaitForJob(); job != nil; job = mypool.WaitForJob() {
+				for k := 0; k < fanout && added < b.N; k++ {
+					mypool.Add(spin, added)
+					added++
+				}
+			}
+			reportJobs(b, start)
+			mypool.Stop()
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package tui
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fwang2/pi/fs"
+	"github.com/fwang2/pi/util"
+)
+
+// Entry ... one row of the browser
+type Entry struct {
+	Name   string
+	Path   string
+	IsDir  bool
+	Bytes  int64
+	Inodes int64 // beneath a directory, 1 for a file
+}
+
+// Browser ... state of an ncdu-like view of a rolled up fs.DirTree.
+// It is driven by HandleKey and drawn by Render, the terminal is
+// only touched by Run.
+type Browser struct {
+	Tree     *fs.DirTree
+	ReadOnly bool                    // no deletion, e.g. the tree is from an index
+	Partial  bool                    // the walk was stopped, sizes are short: no deletion
+	Remove   func(path string) error // deletes an entry, os.RemoveAll by default
+
+	dir      string
+	cursor   int
+	offset   int
+	byInodes bool
+	marked   map[string]bool
+	confirm  []Entry // waiting for y/n on these
+	status   string
+	height   int // rows of entries in the last Render
+}
+
+// NewBrowser ... a browser at the root of a rolled up tree
+func NewBrowser(tree *fs.DirTree) *Browser {
+	return &Browser{
+		Tree:   tree,
+		Remove: os.RemoveAll,
+		dir:    tree.Root,
+		marked: make(map[string]bool),
+		height: 20,
+	}
+}
+
+// Dir ... the directory being shown
+func (b *Browser) Dir() string {
+	return b.dir
+}
+
+// Entries ... the rows of the current directory, sorted
+func (b *Browser) Entries() []Entry {
+	var list []Entry
+	for _, n := range b.Tree.Children(b.dir) {
+		list = append(list, Entry{Name: filepath.Base(n.Path), Path: n.Path,
+			IsDir: true, Bytes: n.Bytes, Inodes: n.Inodes})
+	}
+	for _, f := range b.Tree.Files(b.dir) {
+		list = append(list, Entry{Name: f.Name, Path: filepath.Join(b.dir, f.Name),
+			Bytes: f.Bytes, Inodes: 1})
+	}
+	key := func(e Entry) int64 {
+		if b.byInodes {
+			return e.Inodes
+		}
+		return e.Bytes
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if key(list[i]) != key(list[j]) {
+			return key(list[i]) > key(list[j])
+		}
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// Current ... the entry under the cursor
+func (b *Browser) Current() (Entry, bool) {
+	list := b.Entries()
+	if b.cursor < len(list) {
+		return list[b.cursor], true
+	}
+	return Entry{}, false
+}
+
+// Marked ... paths marked for deletion, sorted
+func (b *Browser) Marked() []string {
+	var list []string
+	for p := range b.marked {
+		list = append(list, p)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// HandleKey ... act on a key press, false once the user quits
+func (b *Browser) HandleKey(k Key) bool {
+	if b.confirm != nil {
+		if k == 'y' || k == 'Y' {
+			b.delete(b.confirm)
+		} else {
+			b.status = "Deletion cancelled"
+		}
+		b.confirm = nil
+		return true
+	}
+	b.status = ""
+
+	n := len(b.Entries())
+	switch k {
+	case 'q', 'Q':
+		return false
+	case KeyUp, 'k':
+		b.move(-1, n)
+	case KeyDown, 'j':
+		b.move(1, n)
+	case KeyPgUp:
+		b.move(-b.height, n)
+	case KeyPgDn:
+		b.move(b.height, n)
+	case KeyHome, 'g':
+		b.move(-n, n)
+	case KeyEnd, 'G':
+		b.move(n, n)
+	case KeyRight, KeyEnter, 'l':
+		if e, ok := b.Current(); ok && e.IsDir {
+			b.dir = e.Path
+			b.cursor, b.offset = 0, 0
+		}
+	case KeyLeft, KeyBackspace, 'h':
+		b.up()
+	case 's':
+		b.byInodes = false
+		b.cursor, b.offset = 0, 0
+	case 'i', 'c':
+		b.byInodes = true
+		b.cursor, b.offset = 0, 0
+	case ' ', 'm':
+		if e, ok := b.Current(); ok {
+			if b.marked[e.Path] {
+				delete(b.marked, e.Path)
+			} else {
+				b.marked[e.Path] = true
+			}
+			b.move(1, n)
+		}
+	case 'd':
+		b.askDelete()
+	}
+	return true
+}
+
+func (b *Browser) move(delta int, n int) {
+	b.cursor += delta
+	if b.cursor >= n {
+		b.cursor = n - 1
+	}
+	if b.cursor < 0 {
+		b.cursor = 0
+	}
+}
+
+// up ... back to the parent, with the cursor on where we came from
+func (b *Browser) up() {
+	if b.dir == b.Tree.Root {
+		return
+	}
+	from := b.dir
+	b.dir = filepath.Dir(b.dir)
+	b.cursor, b.offset = 0, 0
+	for i, e := range b.Entries() {
+		if e.Path == from {
+			b.cursor = i
+			break
+		}
+	}
+}
+
+// askDelete ... the marked entries, or the current one if none is
+func (b *Browser) askDelete() {
+	if b.ReadOnly {
+		b.status = "Read only, can't delete"
+		return
+	}
+	if b.Partial {
+		b.status = "Partial scan, sizes are incomplete, can't delete"
+		return
+	}
+	var targets []Entry
+	if len(b.marked) == 0 {
+		if e, ok := b.Current(); ok {
+			targets = append(targets, e)
+		}
+	} else {
+		for _, p := range b.Marked() {
+			targets = append(targets, b.entry(p))
+		}
+	}
+	if len(targets) == 0 {
+		return
+	}
+	var bytes int64
+	for _, e := range targets {
+		bytes += e.Bytes
+	}
+	b.confirm = targets
+	if len(targets) == 1 {
+		b.status = fmt.Sprintf("Delete %s (%s)? [y/N]", targets[0].Path, util.ShortByte(bytes))
+	} else {
+		b.status = fmt.Sprintf("Delete %d marked entries (%s)? [y/N]", len(targets), util.ShortByte(bytes))
+	}
+}
+
+// entry ... look up a marked path, which may not be in the current dir
+func (b *Browser) entry(path string) Entry {
+	if n := b.Tree.Dir(path); n != nil {
+		return Entry{Name: filepath.Base(path), Path: path, IsDir: true, Bytes: n.Bytes, Inodes: n.Inodes}
+	}
+	for _, f := range b.Tree.Files(filepath.Dir(path)) {
+		if f.Name == filepath.Base(path) {
+			return Entry{Name: f.Name, Path: path, Bytes: f.Bytes, Inodes: 1}
+		}
+	}
+	return Entry{Name: filepath.Base(path), Path: path}
+}
+
+func (b *Browser) delete(targets []Entry) {
+	var deleted, failed int
+	var freed int64
+	var lastErr string
+	for _, e := range targets {
+		if b.Tree.Dir(filepath.Dir(e.Path)) == nil {
+			// went with a marked ancestor
+			delete(b.marked, e.Path)
+			continue
+		}
+		if err := b.Remove(e.Path); err != nil {
+			failed++
+			lastErr = fmt.Sprintf("can't delete %s: %v", e.Path, err)
+			continue
+		}
+		deleted++
+		freed += e.Bytes
+		b.Tree.Remove(e.Path)
+		delete(b.marked, e.Path)
+	}
+	b.status = fmt.Sprintf("Deleted %d entries, %s freed", deleted, util.ShortByte(freed))
+	if failed != 0 {
+		b.status += fmt.Sprintf(", %d failed, %s", failed, lastErr)
+	}
+	// the current directory may be gone
+	for b.Tree.Dir(b.dir) == nil && b.dir != b.Tree.Root {
+		b.dir = filepath.Dir(b.dir)
+		b.cursor, b.offset = 0, 0
+	}
+	b.move(0, len(b.Entries()))
+}
+
+// Render ... draw the whole screen
+func (b *Browser) Render(w io.Writer, width int, height int) {
+	list := b.Entries()
+	b.height = height - 4
+	if b.height < 1 {
+		b.height = 1
+	}
+	// keep the cursor on screen
+	if b.cursor < b.offset {
+		b.offset = b.cursor
+	}
+	if b.cursor >= b.offset+b.height {
+		b.offset = b.cursor - b.height + 1
+	}
+
+	var max int64 = 1
+	for _, e := range list {
+		if v := b.sortKey(e); v > max {
+			max = v
+		}
+	}
+	sortBy := "size"
+	if b.byInodes {
+		sortBy = "inodes"
+	}
+
+	fmt.Fprintf(w, "\x1b[H\x1b[2J")
+	title := fmt.Sprintf("pi browse: %s (sorted by %s)", b.dir, sortBy)
+	if b.Partial {
+		title += " *** partial scan ***"
+	}
+	line(w, width, title, true)
+	line(w, width, strings.Repeat("-", width), false)
+	for i := b.offset; i < len(list) && i < b.offset+b.height; i++ {
+		e := list[i]
+		mark := " "
+		if b.marked[e.Path] {
+			mark = "*"
+		}
+		name := e.Name
+		if e.IsDir {
+			name += "/"
+		}
+		bar := int(b.sortKey(e) * 10 / max)
+		row := fmt.Sprintf("%s %11s %10s [%-10s] %s", mark, util.ShortByte(e.Bytes),
+			util.Comma(e.Inodes), strings.Repeat("#", bar), name)
+		if i == b.cursor {
+			fmt.Fprintf(w, "\x1b[7m")
+			line(w, width, row, true)
+			fmt.Fprintf(w, "\x1b[0m")
+		} else {
+			line(w, width, row, false)
+		}
+	}
+	for i := len(list) - b.offset; i < b.height; i++ {
+		line(w, width, "", false)
+	}
+
+	var total Entry
+	if n := b.Tree.Dir(b.dir); n != nil {
+		total = Entry{Bytes: n.Bytes, Inodes: n.Inodes}
+	}
+	line(w, width, fmt.Sprintf("Total %s, %s inodes, %d marked",
+		util.ShortByte(total.Bytes), util.Comma(total.Inodes), len(b.marked)), false)
+	status := b.status
+	if status == "" {
+		status = "arrows/hjkl move  enter open  s/i sort  space mark  d delete  q quit"
+	}
+	fmt.Fprintf(w, "%s", clip(status, width))
+}
+
+func (b *Browser) sortKey(e Entry) int64 {
+	if b.byInodes {
+		return e.Inodes
+	}
+	return e.Bytes
+}
+
+// line ... one row, clipped to width, padded if reverse video
+func line(w io.Writer, width int, s string, pad bool) {
+	s = clip(s, width)
+	if pad {
+		s += strings.Repeat(" ", width-len([]rune(s)))
+	}
+	fmt.Fprintf(w, "%s\r\n", s)
+}
+
+func clip(s string, width int) string {
+	if r := []rune(s); len(r) > width {
+		return string(r[:width])
+	}
+	return s
+}
+
+// Run ... browse the tree on the terminal until the user quits
+func Run(b *Browser) error {
+	t, err := OpenTerminal()
+	if err != nil {
+		return err
+	}
+	defer t.Close()
+
+	for {
+		width, height := t.Size()
+		b.Render(t.out, width, height)
+		if err := t.out.Flush(); err != nil {
+			return err
+		}
+		k, err := t.ReadKey()
+		if err != nil {
+			return err
+		}
+		if !b.HandleKey(k) {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package tui
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/fwang2/pi/fs"
+	"github.com/stretchr/testify/assert"
+)
+
+// testTree ... /r/{small, a/{big, c/x}, b/{1, 2, 3}}
+func testTree() *fs.DirTree {
+	t := fs.NewDirTree("/r")
+	t.Add("/r", 10, 3)
+	t.AddFiles("/r", []fs.TreeFile{{Name: "small", Bytes: 10}})
+	t.Add("/r/a", 1000, 2)
+	t.AddFiles("/r/a", []fs.TreeFile{{Name: "big", Bytes: 1000}})
+	t.Add("/r/a/c", 5, 1)
+	t.AddFiles("/r/a/c", []fs.TreeFile{{Name: "x", Bytes: 5}})
+	t.Add("/r/b", 30, 3)
+	t.AddFiles("/r/b", []fs.TreeFile{{Name: "1", Bytes: 10}, {Name: "2", Bytes: 10}, {Name: "3", Bytes: 10}})
+	t.Rollup()
+	return t
+}
+
+func names(b *Browser) []string {
+	var list []string
+	for _, e := range b.Entries() {
+		list = append(list, e.Name)
+	}
+	return list
+}
+
+func TestBrowserNavigate(t *testing.T) {
+	b := NewBrowser(testTree())
+	assert.Equal(t, []string{"a", "b", "small"}, names(b))
+
+	b.HandleKey('i')
+	assert.Equal(t, []string{"a", "b", "small"}, names(b))
+	b.HandleKey(KeyDown)
+	b.HandleKey(KeyEnter)
+	assert.Equal(t, "/r/b", b.Dir())
+	assert.Equal(t, []string{"1", "2", "3"}, names(b))
+
+	// can't open a file, or go above the root
+	b.HandleKey(KeyEnter)
+	assert.Equal(t, "/r/b", b.Dir())
+	b.HandleKey(KeyLeft)
+	assert.Equal(t, "/r", b.Dir())
+	e, _ := b.Current()
+	assert.Equal(t, "b", e.Name)
+	b.HandleKey(KeyLeft)
+	assert.Equal(t, "/r", b.Dir())
+
+	b.HandleKey('s')
+	b.HandleKey(KeyEnd)
+	e, _ = b.Current()
+	assert.Equal(t, "small", e.Name)
+	assert.False(t, b.HandleKey('q'))
+}
+
+func TestBrowserDelete(t *testing.T) {
+	var removed []string
+	b := NewBrowser(testTree())
+	b.Remove = func(path string) error {
+		removed = append(removed, path)
+		return nil
+	}
+
+	// mark a, and c inside it, then b/1
+	b.HandleKey(' ')
+	b.HandleKey(KeyUp)
+	b.HandleKey(KeyEnter)
+	b.HandleKey(KeyDown)
+	b.HandleKey('m')
+	assert.Equal(t, []string{"/r/a", "/r/a/c"}, b.Marked())
+
+	// anything but y cancels
+	b.HandleKey('d')
+	b.HandleKey('n')
+	assert.Nil(t, removed)
+	assert.Equal(t, 2, len(b.Marked()))
+
+	b.HandleKey('d')
+	b.HandleKey('y')
+	assert.Equal(t, []string{"/r/a"}, removed)
+	assert.Equal(t, "Deleted 1 entries, 0.98 KiB freed", b.status)
+	assert.Equal(t, 0, len(b.Marked()))
+	assert.Equal(t, "/r", b.Dir())
+	assert.Equal(t, []string{"b", "small"}, names(b))
+	assert.Equal(t, int64(40), b.Tree.Dir("/r").Bytes)
+	assert.Equal(t, int64(5), b.Tree.Dir("/r").Inodes)
+
+	// no marks, the current entry goes
+	b.HandleKey(KeyEnter)
+	b.HandleKey('d')
+	b.HandleKey('y')
+	assert.Equal(t, "/r/b/1", removed[1])
+	assert.Equal(t, int64(20), b.Tree.Dir("/r/b").Bytes)
+	assert.Equal(t, int64(30), b.Tree.Dir("/r").Bytes)
+
+	// only the removals that succeed are counted
+	b.Remove = func(path string) error {
+		if path == "/r/b/3" {
+			return errors.New("permission denied")
+		}
+		removed = append(removed, path)
+		return nil
+	}
+	b.HandleKey(' ')
+	b.HandleKey(' ')
+	b.HandleKey('d')
+	b.HandleKey('y')
+	assert.Equal(t, "/r/b/2", removed[2])
+	assert.Equal(t, "Deleted 1 entries, 0.01 KiB freed, 1 failed, can't delete /r/b/3: permission denied", b.status)
+	assert.Equal(t, []string{"/r/b/3"}, b.Marked())
+
+	b.ReadOnly = true
+	b.HandleKey('d')
+	b.HandleKey('y')
+	assert.Equal(t, 3, len(removed))
+
+	b.ReadOnly, b.Partial = false, true
+	b.HandleKey('d')
+	assert.Equal(t, "Partial scan, sizes are incomplete, can't delete", b.status)
+	b.HandleKey('y')
+	assert.Equal(t, 3, len(removed))
+}
+
+func TestBrowserRender(t *testing.T) {
+	b := NewBrowser(testTree())
+	b.HandleKey(' ')
+	var buf bytes.Buffer
+	b.Render(&buf, 60, 10)
+	out := buf.String()
+	assert.Contains(t, out, "pi browse: /r (sorted by size)")
+	assert.Contains(t, out, "a/")
+	assert.Contains(t, out, "[##########]")
+	assert.Contains(t, out, "1 marked")
+	assert.NotContains(t, out, "partial")
+	for _, l := range strings.Split(out, "\r\n") {
+		assert.True(t, len([]rune(l)) <= 60+len("\x1b[H\x1b[2J\x1b[7m"))
+	}
+
+	b.Partial = true
+	buf.Reset()
+	b.Render(&buf, 80, 10)
+	assert.Contains(t, buf.String(), "pi browse: /r (sorted by size) *** partial scan ***")
+}
+
+func TestParseKey(t *testing.T) {
+	assert.Equal(t, KeyUp, parseKey([]byte("\x1b[A")))
+	assert.Equal(t, KeyPgDn, parseKey([]byte("\x1b[6~")))
+	assert.Equal(t, KeyEnter, parseKey([]byte("\r")))
+	assert.Equal(t, KeyBackspace, parseKey([]byte{0x7f}))
+	assert.Equal(t, Key('q'), parseKey([]byte("q")))
+	assert.Equal(t, KeyUnknown, parseKey([]byte("\x1b[Z")))
+}
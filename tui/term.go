@@ -0,0 +1,116 @@
+package tui
+
+import (
+	"bufio"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Key ... a key press, a rune or one of the Key* constants
+type Key rune
+
+// keys without a rune
+const (
+	KeyUp Key = -(iota + 1)
+	KeyDown
+	KeyLeft
+	KeyRight
+	KeyPgUp
+	KeyPgDn
+	KeyHome
+	KeyEnd
+	KeyEnter
+	KeyBackspace
+	KeyUnknown
+)
+
+// escape sequences of the special keys, in normal and application mode
+var escKeys = map[string]Key{
+	"[A": KeyUp, "OA": KeyUp,
+	"[B": KeyDown, "OB": KeyDown,
+	"[C": KeyRight, "OC": KeyRight,
+	"[D": KeyLeft, "OD": KeyLeft,
+	"[5~": KeyPgUp, "[6~": KeyPgDn,
+	"[H": KeyHome, "OH": KeyHome, "[1~": KeyHome,
+	"[F": KeyEnd, "OF": KeyEnd, "[4~": KeyEnd,
+}
+
+// Terminal ... a tty in raw mode, on the alternate screen
+type Terminal struct {
+	in  *os.File
+	out *bufio.Writer
+	fd  int
+	old unix.Termios
+}
+
+// OpenTerminal ... switch stdin/stdout to raw mode, fails if they are
+// not a terminal
+func OpenTerminal() (*Terminal, error) {
+	fd := int(os.Stdin.Fd())
+	old, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return nil, err
+	}
+	raw := *old
+	raw.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	raw.Oflag &^= unix.OPOST
+	raw.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	raw.Cflag &^= unix.CSIZE | unix.PARENB
+	raw.Cflag |= unix.CS8
+	raw.Cc[unix.VMIN] = 1
+	raw.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &raw); err != nil {
+		return nil, err
+	}
+
+	t := &Terminal{in: os.Stdin, out: bufio.NewWriter(os.Stdout), fd: fd, old: *old}
+	// alternate screen, hidden cursor
+	t.out.WriteString("\x1b[?1049h\x1b[?25l")
+	t.out.Flush()
+	return t, nil
+}
+
+// Close ... restore the terminal as it was
+func (t *Terminal) Close() error {
+	t.out.WriteString("\x1b[?25h\x1b[?1049l")
+	t.out.Flush()
+	return unix.IoctlSetTermios(t.fd, ioctlSetTermios, &t.old)
+}
+
+// Size ... columns and rows, 80x24 if unknown
+func (t *Terminal) Size() (width int, height int) {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 {
+		return 80, 24
+	}
+	return int(ws.Col), int(ws.Row)
+}
+
+// ReadKey ... wait for the next key press
+func (t *Terminal) ReadKey() (Key, error) {
+	buf := make([]byte, 16)
+	n, err := t.in.Read(buf)
+	if err != nil {
+		return KeyUnknown, err
+	}
+	return parseKey(buf[:n]), nil
+}
+
+func parseKey(b []byte) Key {
+	switch {
+	case len(b) == 0:
+		return KeyUnknown
+	case b[0] == 0x1b && len(b) > 1:
+		if k, ok := escKeys[string(b[1:])]; ok {
+			return k
+		}
+		return KeyUnknown
+	case b[0] == '\r' || b[0] == '\n':
+		return KeyEnter
+	case b[0] == 0x7f || b[0] == 0x08:
+		return KeyBackspace
+	}
+	return Key([]rune(string(b))[0])
+}
@@ -5,11 +5,15 @@ package util
 
 import (
 	"errors"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
 const (
-	Day = 24 * time.Hour
+	Day  = 24 * time.Hour
+	Year = 365 * Day
 )
 
 var unitMap = map[string]int64{
@@ -22,6 +26,8 @@ var unitMap = map[string]int64{
 	"m":  int64(time.Minute),
 	"h":  int64(time.Hour),
 	"d":  int64(Day),
+	"w":  int64(7 * Day),
+	"y":  int64(Year),
 }
 
 // ParseDuration parses a duration string.
@@ -31,7 +37,8 @@ var unitMap = map[string]int64{
 // A duration string is a possibly signed sequence of
 // decimal numbers, each with optional fraction and a unit suffix,
 // such as "300ms", "-1.5h" or "2h45m".
-// Valid time units are "ns", "us" (or "µs"), "ms", "s", "m", "h".
+// Valid time units are "ns", "us" (or "µs"), "ms", "s", "m", "h",
+// and "d", "w" and "y" (365d).
 func ParseDuration(s string) (time.Duration, bool, error) {
 	// [-+]?([0-9]*(\.[0-9]*)?[a-z]+)+
 	orig := s
@@ -128,6 +135,36 @@ func ParseDuration(s string) (time.Duration, bool, error) {
 
 }
 
+// ShortDuration ... d in whole years or days when it can be,
+// e.g. 3y or 90d, as time.Duration does otherwise
+func ShortDuration(d time.Duration) string {
+	switch {
+	case d != 0 && d%Year == 0:
+		return strconv.FormatInt(int64(d/Year), 10) + "y"
+	case d != 0 && d%Day == 0:
+		return strconv.FormatInt(int64(d/Day), 10) + "d"
+	}
+	return d.String()
+}
+
+// BinsToDuration ... parse age bins such as "1d,7d,30d,1y" into
+// sorted durations
+func BinsToDuration(bins string) ([]time.Duration, error) {
+	var dbins []time.Duration
+	for _, v := range strings.Split(bins, ",") {
+		d, neg, err := ParseDuration(strings.TrimSpace(v))
+		if err != nil {
+			return nil, err
+		}
+		if neg || d == 0 {
+			return nil, errors.New("age bins must be positive: " + v)
+		}
+		dbins = append(dbins, d)
+	}
+	sort.Slice(dbins, func(i, j int) bool { return dbins[i] < dbins[j] })
+	return dbins, nil
+}
+
 var errLeadingInt = errors.New("time: bad [0-9]*") // never printed
 
 // leadingInt consumes the leading [0-9]* from s.
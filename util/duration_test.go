@@ -9,19 +9,39 @@ import (
 
 func TestMinutes(t *testing.T) {
 	s := "3m"
-	du, _ := ParseDuration(s)
+	du, _, _ := ParseDuration(s)
 	assert.Equal(t, 180.0, du.Seconds())
 }
 
 func TestHourMin(t *testing.T) {
 	s := "1h1m"
-	du, _ := ParseDuration(s)
+	du, _, _ := ParseDuration(s)
 	assert.Equal(t, (61 * time.Minute).Minutes(), du.Minutes())
 }
 
 func TestDayHour(t *testing.T) {
 	s := "1d1h"
-	du, _ := ParseDuration(s)
+	du, _, _ := ParseDuration(s)
 	assert.Equal(t, (25 * time.Hour).Minutes(), du.Minutes())
 
 }
+
+func TestShortDuration(t *testing.T) {
+	assert.Equal(t, "3y", ShortDuration(3*Year))
+	assert.Equal(t, "90d", ShortDuration(90*Day))
+	assert.Equal(t, "1h30m0s", ShortDuration(90*time.Minute))
+	assert.Equal(t, "0s", ShortDuration(0))
+}
+
+func TestBinsToDuration(t *testing.T) {
+	bins, err := BinsToDuration("7d, 1d,1y,3y,2w")
+	assert.Nil(t, err)
+	assert.Equal(t, []time.Duration{Day, 7 * Day, 14 * Day, Year, 3 * Year}, bins)
+
+	_, err = BinsToDuration("1d,-7d")
+	assert.NotNil(t, err)
+	_, err = BinsToDuration("1d,,7d")
+	assert.NotNil(t, err)
+	_, err = BinsToDuration("1x")
+	assert.NotNil(t, err)
+}
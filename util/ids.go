@@ -0,0 +1,77 @@
+package util
+
+import (
+	"os/user"
+	"strconv"
+	"sync"
+)
+
+// Name lookups go to the user database (and possibly NSS, LDAP, ...)
+// for every call, so they are cached for the life of the process.
+var (
+	idMutex    sync.RWMutex
+	userNames  = map[uint32]string{}
+	groupNames = map[uint32]string{}
+)
+
+// UserName ... name of uid, false if uid is not in the user database
+func UserName(uid uint32) (string, bool) {
+	return cachedName(userNames, uid, func(id string) (string, error) {
+		u, err := user.LookupId(id)
+		if err != nil {
+			return "", err
+		}
+		return u.Username, nil
+	})
+}
+
+// GroupName ... name of gid, false if gid is not in the group database
+func GroupName(gid uint32) (string, bool) {
+	return cachedName(groupNames, gid, func(id string) (string, error) {
+		g, err := user.LookupGroupId(id)
+		if err != nil {
+			return "", err
+		}
+		return g.Name, nil
+	})
+}
+
+// an empty name in the cache marks an id we failed to look up
+func cachedName(cache map[uint32]string, id uint32, lookup func(string) (string, error)) (string, bool) {
+	idMutex.RLock()
+	name, ok := cache[id]
+	idMutex.RUnlock()
+	if !ok {
+		name, _ = lookup(strconv.FormatUint(uint64(id), 10))
+		idMutex.Lock()
+		cache[id] = name
+		idMutex.Unlock()
+	}
+	return name, name != ""
+}
+
+// LookupUID ... uid of a user name, a numeric string is taken as is
+func LookupUID(s string) (uint32, error) {
+	if id, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	u, err := user.Lookup(s)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(u.Uid, 10, 32)
+	return uint32(id), err
+}
+
+// LookupGID ... gid of a group name, a numeric string is taken as is
+func LookupGID(s string) (uint32, error) {
+	if id, err := strconv.ParseUint(s, 10, 32); err == nil {
+		return uint32(id), nil
+	}
+	g, err := user.LookupGroup(s)
+	if err != nil {
+		return 0, err
+	}
+	id, err := strconv.ParseUint(g.Gid, 10, 32)
+	return uint32(id), err
+}
@@ -0,0 +1,28 @@
+package util
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLookupIDs(t *testing.T) {
+	uid, err := LookupUID("0")
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), uid)
+
+	name, ok := UserName(0)
+	assert.True(t, ok)
+	uid, err = LookupUID(name)
+	assert.Nil(t, err)
+	assert.Equal(t, uint32(0), uid)
+
+	_, ok = UserName(4000000000)
+	assert.False(t, ok)
+	// second call is served from the cache
+	_, ok = UserName(4000000000)
+	assert.False(t, ok)
+
+	_, err = LookupGID("no-such-group-here")
+	assert.NotNil(t, err)
+}
@@ -1,16 +1,17 @@
 package util
 
 import (
+	"sync"
 	"testing"
 )
 
 func TestSortedQueue(t *testing.T) {
 	data := []Item{
-		{"Alice", 23},
-		{"Eve", 2},
-		{"Bob", 25},
-		{"Qiqi", 35},
-		{"Yang", 96},
+		{Name: "Alice", Val: 23},
+		{Name: "Eve", Val: 2},
+		{Name: "Bob", Val: 25},
+		{Name: "Qiqi", Val: 35},
+		{Name: "Yang", Val: 96},
 	}
 
 	q := NewSortedQueue(3)
@@ -39,3 +40,29 @@ func TestSortedQueue(t *testing.T) {
 		t.Errorf("Incorrect content %v\n", q.items)
 	}
 }
+
+func TestSortedQueueConcurrent(t *testing.T) {
+	q := NewSortedQueue(5)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				q.Put(Item{Name: "x", Val: int64(i*1000 + j)})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	items := q.Items()
+	if len(items) != 5 || items[0].Val != 7995 || items[4].Val != 7999 {
+		t.Errorf("Incorrect content %v\n", items)
+	}
+
+	q = NewSortedQueue(0)
+	q.Put(Item{Name: "x", Val: 1})
+	if len(q.Items()) != 0 {
+		t.Errorf("Incorrect length: %d\n", len(q.Items()))
+	}
+}
@@ -11,3 +11,9 @@ func StatsTime(stat *syscall.Stat_t) (atime, ctime, mtime time.Time) {
 	mtime = time.Unix(int64(stat.Mtimespec.Sec), int64(stat.Mtimespec.Nsec))
 	return
 }
+
+func SetStatsTime(stat *syscall.Stat_t, atime, ctime, mtime time.Time) {
+	stat.Atimespec = syscall.NsecToTimespec(atime.UnixNano())
+	stat.Ctimespec = syscall.NsecToTimespec(ctime.UnixNano())
+	stat.Mtimespec = syscall.NsecToTimespec(mtime.UnixNano())
+}
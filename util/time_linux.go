@@ -11,3 +11,9 @@ func StatsTime(stat *syscall.Stat_t) (atime, ctime, mtime time.Time) {
 	mtime = time.Unix(int64(stat.Mtim.Sec), int64(stat.Mtim.Nsec))
 	return
 }
+
+func SetStatsTime(stat *syscall.Stat_t, atime, ctime, mtime time.Time) {
+	stat.Atim = syscall.NsecToTimespec(atime.UnixNano())
+	stat.Ctim = syscall.NsecToTimespec(ctime.UnixNano())
+	stat.Mtim = syscall.NsecToTimespec(mtime.UnixNano())
+}
@@ -7,6 +7,7 @@ import (
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 )
 
 // Define a set of common unit
@@ -47,6 +48,7 @@ func StrBytes(s string) int64 {
 type Item struct {
 	Name string
 	Val  int64
+	Time int64 // a time shown along, in UnixNano, 0 if none
 }
 
 // ItemList implement sort.Interface
@@ -61,8 +63,10 @@ func (a ItemList) Less(i, j int) bool { return a[i].Val < a[j].Val }
 // Swap two element
 func (a ItemList) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
 
-// SortedQueue provide an ordered queue with fixed length
+// SortedQueue provide an ordered queue with fixed length,
+// safe for concurrent use
 type SortedQueue struct {
+	mu       sync.Mutex
 	capacity int
 	items    ItemList
 }
@@ -77,7 +81,12 @@ func NewSortedQueue(cap int) (oq *SortedQueue) {
 
 // Put add new items
 func (oq *SortedQueue) Put(it Item) {
+	oq.mu.Lock()
+	defer oq.mu.Unlock()
 
+	if oq.capacity == 0 {
+		return
+	}
 	if len(oq.items) == oq.capacity {
 		if oq.items[0].Val < it.Val {
 			oq.items[0] = it
@@ -89,9 +98,16 @@ func (oq *SortedQueue) Put(it Item) {
 
 }
 
+// Cap ... the most items kept
+func (oq *SortedQueue) Cap() int {
+	return oq.capacity
+}
+
 // Items ... return items
 func (oq *SortedQueue) Items() ItemList {
-	return oq.items
+	oq.mu.Lock()
+	defer oq.mu.Unlock()
+	return append(ItemList(nil), oq.items...)
 }
 
 // ShortNum ... shorten a number
@@ -217,8 +233,8 @@ func BinsToNum(bins string) []int64 {
 			log.Fatalf("Unknown unit in bins: %s\n", bins)
 		}
 	}
-	nbins[len(nbins)-1] = GUARD
 	sort.Slice(nbins, func(i, j int) bool { return nbins[i] < nbins[j] })
+	nbins = append(nbins, GUARD)
 	return nbins
 }
 